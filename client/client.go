@@ -0,0 +1,316 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/valyala/fasthttp"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	APP_TOKEN_HEADER = "X-App-Token"
+	CURRENT_TOKEN_HEADER = "X-Current-Token"
+	ERRCODE_NOT_MODIFIED = 30400 //长轮询超时，没有更新的accessToken
+	DEFAULT_TIMEOUT = 5*time.Second
+	REFRESH_WAIT = 10            //刷新后等待新accessToken的时间，秒
+	WATCH_WAIT = 60              //后台长轮询每次等待的时间，秒
+	WATCH_RETRY = 5*time.Second  //后台长轮询出错后的重试间隔
+)
+
+var (
+	ErrAppNotAdded = errors.New("appid not added to client")
+	ErrRefreshTimeout = errors.New("no new accesstoken after refresh")
+	ErrClientClosed = errors.New("client closed")
+)
+
+//与服务端/query接口返回的Result相同
+type Result struct {
+	Errcode     int    `json:"errcode"`
+	AccessToken string `json:"accessToken"`
+	Msg         string `json:"msg"`
+	ServerTime  int64  `json:"serverTime"`
+	ExpireAt    int64  `json:"expireAt"`
+	UpdateTime  int64  `json:"updateTime"` //只有长轮询接口返回
+}
+
+//服务端返回的错误，Errcode和Status与服务端的errcode及http状态码相同
+type Error struct {
+	Status  int
+	Errcode int
+	Msg     string
+}
+
+func (e *Error) Error() string{
+	return "wechat token server errcode "+strconv.Itoa(e.Errcode)+": "+e.Msg
+}
+
+//微信接口返回40001，42001时表示accessToken无效或已过期
+func IsTokenInvalid(errcode int) bool{
+	return errcode == 40001 || errcode == 42001
+}
+
+//正在进行的请求，同一个应用的并发请求共用结果
+type call struct {
+	done   chan int
+	result string
+	err    error
+}
+
+type app struct {
+	appid       string
+	token       string
+	accessToken string
+	localExpire time.Time //按照本地时钟计算的过期时间
+	fetching    *call
+	refreshing  *call
+}
+
+//wechatTokenServer的客户端，accessToken在expireAt之前使用本地缓存
+type Client struct {
+	sync.Mutex
+	baseURL    string
+	httpClient *fasthttp.Client
+	timeout    time.Duration
+	apps       map[string]*app
+	stopChan   chan int
+	closed     bool
+}
+
+//baseURL为服务地址，例如http://127.0.0.1:9999
+func NewClient(baseURL string) *Client{
+	return &Client{
+		baseURL:strings.TrimRight(baseURL,"/"),
+		httpClient:&fasthttp.Client{},
+		timeout:DEFAULT_TIMEOUT,
+		apps:make(map[string]*app),
+		stopChan:make(chan int),
+	}
+}
+
+func (c *Client) SetTimeout(timeout time.Duration){
+	c.Lock()
+	c.timeout = timeout
+	c.Unlock()
+}
+
+//添加应用，token为服务端该应用配置的查询校验token
+func (c *Client) AddApp(appid,token string){
+	c.Lock()
+	defer c.Unlock()
+	if a,ok := c.apps[appid];ok{
+		a.token = token
+		return
+	}
+	c.apps[appid] = &app{appid:appid,token:token}
+}
+
+func (c *Client) getApp(appid string) (*app,error){
+	c.Lock()
+	defer c.Unlock()
+	if c.closed{
+		return nil,ErrClientClosed
+	}
+	a,ok := c.apps[appid]
+	if !ok{
+		return nil,ErrAppNotAdded
+	}
+	return a,nil
+}
+
+//返回应用的accessToken，缓存未过期时不请求服务端，同一应用的并发请求只请求一次
+func (c *Client) AccessToken(appid string) (string,error){
+	a,err := c.getApp(appid)
+	if err != nil{
+		return "",err
+	}
+	c.Lock()
+	if a.accessToken != "" && time.Now().Before(a.localExpire){
+		accessToken := a.accessToken
+		c.Unlock()
+		return accessToken,nil
+	}
+	if a.fetching != nil{
+		fetching := a.fetching
+		c.Unlock()
+		<-fetching.done
+		return fetching.result,fetching.err
+	}
+	fetching := &call{done:make(chan int)}
+	a.fetching = fetching
+	token := a.token
+	c.Unlock()
+
+	result,err := c.get("/v1/apps/"+url.PathEscape(appid)+"/token",token,"",c.getTimeout())
+	c.Lock()
+	if err == nil{
+		c.setToken(a,result)
+		fetching.result = result.AccessToken
+	}
+	fetching.err = err
+	a.fetching = nil
+	c.Unlock()
+	close(fetching.done)
+	return fetching.result,fetching.err
+}
+
+//调用方请求微信接口返回errcode时调用，40001和42001时请求服务端刷新并等待新的accessToken，
+//其他errcode原样返回accessToken。多个调用方同时上报同一个accessToken时只刷新一次
+func (c *Client) ReportErrcode(appid,accessToken string,errcode int) (string,error){
+	if !IsTokenInvalid(errcode){
+		return accessToken,nil
+	}
+	a,err := c.getApp(appid)
+	if err != nil{
+		return "",err
+	}
+	c.Lock()
+	//其他调用方已经获取到新的accessToken
+	if a.accessToken != "" && a.accessToken != accessToken && time.Now().Before(a.localExpire){
+		current := a.accessToken
+		c.Unlock()
+		return current,nil
+	}
+	if a.refreshing != nil{
+		refreshing := a.refreshing
+		c.Unlock()
+		<-refreshing.done
+		return refreshing.result,refreshing.err
+	}
+	refreshing := &call{done:make(chan int)}
+	a.refreshing = refreshing
+	a.localExpire = time.Time{}
+	token := a.token
+	c.Unlock()
+
+	result,err := c.refresh(appid,token,accessToken)
+	c.Lock()
+	if err == nil{
+		c.setToken(a,result)
+		refreshing.result = result.AccessToken
+	}
+	refreshing.err = err
+	a.refreshing = nil
+	c.Unlock()
+	close(refreshing.done)
+	return refreshing.result,refreshing.err
+}
+
+//请求服务端强制刷新，然后长轮询等待比staleToken新的accessToken
+func (c *Client) refresh(appid,token,staleToken string) (*Result,error){
+	path := "/v1/apps/"+url.PathEscape(appid)+"/token"
+	_,err := c.do("POST",path+"/refresh",token,"",c.getTimeout())
+	//follower返回503，由leader刷新，继续等待
+	if e,ok := err.(*Error);err != nil && !(ok && e.Status == fasthttp.StatusServiceUnavailable){
+		return nil,err
+	}
+	result,err := c.get(path+"/wait?timeout="+strconv.Itoa(REFRESH_WAIT),token,staleToken,time.Duration(REFRESH_WAIT)*time.Second+c.getTimeout())
+	if err != nil{
+		return nil,err
+	}
+	if result.Errcode == ERRCODE_NOT_MODIFIED{
+		return nil,ErrRefreshTimeout
+	}
+	return result,nil
+}
+
+//后台对所有已添加的应用长轮询，服务端accessToken更新后立即更新本地缓存，Close后停止
+func (c *Client) Watch(){
+	c.Lock()
+	appids := make([]string,0,len(c.apps))
+	for appid := range c.apps{
+		appids = append(appids,appid)
+	}
+	c.Unlock()
+	for _,appid := range appids{
+		go c.watch(appid)
+	}
+}
+
+func (c *Client) watch(appid string){
+	for{
+		a,err := c.getApp(appid)
+		if err != nil{
+			return
+		}
+		c.Lock()
+		token,current := a.token,a.accessToken
+		c.Unlock()
+		var result *Result
+		if current == ""{
+			_,err = c.AccessToken(appid)
+		}else{
+			result,err = c.get("/v1/apps/"+url.PathEscape(appid)+"/token/wait?timeout="+strconv.Itoa(WATCH_WAIT),
+				token,current,time.Duration(WATCH_WAIT)*time.Second+c.getTimeout())
+		}
+		if err == nil && result != nil && result.Errcode != ERRCODE_NOT_MODIFIED{
+			c.Lock()
+			c.setToken(a,result)
+			c.Unlock()
+		}
+		if err != nil{
+			select{
+			case <-c.stopChan:
+				return
+			case <-time.After(WATCH_RETRY):
+			}
+		}
+	}
+}
+
+//停止后台长轮询，之后的调用返回ErrClientClosed
+func (c *Client) Close(){
+	c.Lock()
+	defer c.Unlock()
+	if c.closed{
+		return
+	}
+	c.closed = true
+	close(c.stopChan)
+}
+
+//调用方需要持有c的锁，按照服务端时间换算本地过期时间，避免两端时钟不一致
+func (c *Client) setToken(a *app,result *Result){
+	if result.AccessToken == ""{
+		return
+	}
+	a.accessToken = result.AccessToken
+	a.localExpire = time.Now().Add(time.Duration(result.ExpireAt-result.ServerTime)*time.Second)
+}
+
+func (c *Client) getTimeout() time.Duration{
+	c.Lock()
+	defer c.Unlock()
+	return c.timeout
+}
+
+func (c *Client) get(path,token,current string,timeout time.Duration) (*Result,error){
+	return c.do("GET",path,token,current,timeout)
+}
+
+func (c *Client) do(method,path,token,current string,timeout time.Duration) (*Result,error){
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI(c.baseURL+path)
+	req.Header.SetMethod(method)
+	req.Header.Set(APP_TOKEN_HEADER,token)
+	if current != ""{
+		req.Header.Set(CURRENT_TOKEN_HEADER,current)
+	}
+	if err := c.httpClient.DoTimeout(req,resp,timeout);err != nil{
+		return nil,err
+	}
+	result := &Result{}
+	if err := json.Unmarshal(resp.Body(),result);err != nil{
+		return nil,errors.New("response error "+err.Error())
+	}
+	if resp.StatusCode() != fasthttp.StatusOK || result.Errcode != 0 && result.Errcode != ERRCODE_NOT_MODIFIED{
+		return result,&Error{Status:resp.StatusCode(),Errcode:result.Errcode,Msg:result.Msg}
+	}
+	return result,nil
+}
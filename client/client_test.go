@@ -0,0 +1,149 @@
+package client
+
+import (
+	"encoding/json"
+	"github.com/valyala/fasthttp"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//模拟wechatTokenServer的v1接口
+type fakeServer struct {
+	sync.Mutex
+	addr      string
+	token     int
+	queries   int32
+	refreshes int32
+}
+
+func (fs *fakeServer) current() string{
+	fs.Lock()
+	defer fs.Unlock()
+	return "token_"+strconv.Itoa(fs.token)
+}
+
+func (fs *fakeServer) handler(ctx *fasthttp.RequestCtx){
+	result := Result{ServerTime:time.Now().Unix(),ExpireAt:time.Now().Unix()+600,Msg:"success"}
+	if string(ctx.Request.Header.Peek(APP_TOKEN_HEADER)) != "token"{
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		result.Errcode = 40400
+	}else{
+		switch string(ctx.Path()){
+		case "/v1/apps/wx/token":
+			atomic.AddInt32(&fs.queries,1)
+			time.Sleep(20*time.Millisecond)
+		case "/v1/apps/wx/token/refresh":
+			atomic.AddInt32(&fs.refreshes,1)
+			fs.Lock()
+			fs.token++
+			fs.Unlock()
+		case "/v1/apps/wx/token/wait":
+			deadline := time.Now().Add(time.Second)
+			for fs.current() == string(ctx.Request.Header.Peek(CURRENT_TOKEN_HEADER)) && time.Now().Before(deadline){
+				time.Sleep(10*time.Millisecond)
+			}
+			if fs.current() == string(ctx.Request.Header.Peek(CURRENT_TOKEN_HEADER)){
+				result.Errcode = ERRCODE_NOT_MODIFIED
+			}
+		}
+		result.AccessToken = fs.current()
+	}
+	body,_ := json.Marshal(result)
+	ctx.SetBody(body)
+}
+
+func startFakeServer(test *testing.T) (*fakeServer,func()){
+	ln,err := net.Listen("tcp","127.0.0.1:0")
+	if err != nil{
+		test.Fatal(err)
+	}
+	fs := &fakeServer{addr:"http://"+ln.Addr().String()}
+	go fasthttp.Serve(ln,fs.handler)
+	return fs,func(){ln.Close()}
+}
+
+func TestAccessTokenCache(test *testing.T){
+	fs,stop := startFakeServer(test)
+	defer stop()
+	c := NewClient(fs.addr)
+	defer c.Close()
+	c.AddApp("wx","token")
+
+	wg := sync.WaitGroup{}
+	for i := 0;i < 10;i++{
+		wg.Add(1)
+		go func(){
+			defer wg.Done()
+			if token,err := c.AccessToken("wx");err != nil || token != "token_0"{
+				test.Error("access token error",token,err)
+			}
+		}()
+	}
+	wg.Wait()
+	c.AccessToken("wx")
+	if atomic.LoadInt32(&fs.queries) != 1{
+		test.Error("concurrent fetch should query once",fs.queries)
+	}
+	if _,err := c.AccessToken("wx_none");err != ErrAppNotAdded{
+		test.Error("unknown app should error",err)
+	}
+	c.AddApp("wx_wrong","wrong")
+	if _,err := c.AccessToken("wx_wrong");err == nil || err.(*Error).Errcode != 40400{
+		test.Error("wrong token should return server error",err)
+	}
+}
+
+func TestReportErrcode(test *testing.T){
+	fs,stop := startFakeServer(test)
+	defer stop()
+	c := NewClient(fs.addr)
+	defer c.Close()
+	c.AddApp("wx","token")
+	stale,_ := c.AccessToken("wx")
+
+	if token,err := c.ReportErrcode("wx",stale,45009);err != nil || token != stale{
+		test.Error("other errcode should not refresh",token,err)
+	}
+	wg := sync.WaitGroup{}
+	for i := 0;i < 5;i++{
+		wg.Add(1)
+		go func(){
+			defer wg.Done()
+			if token,err := c.ReportErrcode("wx",stale,40001);err != nil || token != "token_1"{
+				test.Error("report 40001 should get new token",token,err)
+			}
+		}()
+	}
+	wg.Wait()
+	if atomic.LoadInt32(&fs.refreshes) != 1{
+		test.Error("concurrent report should refresh once",fs.refreshes)
+	}
+	if token,_ := c.AccessToken("wx");token != "token_1"{
+		test.Error("cache should use new token",token)
+	}
+}
+
+func TestWatch(test *testing.T){
+	fs,stop := startFakeServer(test)
+	defer stop()
+	c := NewClient(fs.addr)
+	c.AddApp("wx","token")
+	c.Watch()
+	defer c.Close()
+	time.Sleep(100*time.Millisecond)
+	fs.Lock()
+	fs.token = 5
+	fs.Unlock()
+	deadline := time.Now().Add(2*time.Second)
+	for time.Now().Before(deadline){
+		if token,_ := c.AccessToken("wx");token == "token_5"{
+			return
+		}
+		time.Sleep(20*time.Millisecond)
+	}
+	test.Error("watch should update cached token")
+}
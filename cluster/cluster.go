@@ -0,0 +1,128 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+//多实例部署时的选主，只有leader请求微信接口刷新accessToken，follower使用leader的accessToken
+
+const (
+	LOCK_FILE = "file"
+	LOCK_REDIS = "redis"
+	LOCK_ETCD = "etcd"
+	DEFAULT_LOCK_KEY = "wechatTokenServer:leader"
+	DEFAULT_LOCK_TTL = 15*time.Second
+)
+
+//分布式锁，TryLock获取锁或者为已经持有的锁续期，锁在ttl内没有续期自动释放
+type Locker interface {
+	TryLock(id string,ttl time.Duration) (bool,error)
+	Unlock(id string) error
+}
+
+//默认的节点id为主机名+进程id
+func DefaultNodeID() string{
+	hostname,err := os.Hostname()
+	if err != nil{
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d",hostname,os.Getpid())
+}
+
+//选主，每ttl/3尝试一次获取或者续期锁，获取失败或者出错都视为follower
+type Elector struct {
+	sync.RWMutex
+	id       string
+	locker   Locker
+	ttl      time.Duration
+	leader   bool
+	stopChan chan int
+	onChange func(isLeader bool)
+}
+
+func NewElector(id string,locker Locker,ttl time.Duration) (*Elector,error){
+	if locker == nil{
+		return nil,errors.New("elector need a locker")
+	}
+	if id == ""{
+		id = DefaultNodeID()
+	}
+	if ttl <= 0{
+		ttl = DEFAULT_LOCK_TTL
+	}
+	return &Elector{
+		id:id,
+		locker:locker,
+		ttl:ttl,
+		stopChan:make(chan int,1),
+	},nil
+}
+
+func (e *Elector) ID() string{
+	return e.id
+}
+
+func (e *Elector) IsLeader() bool{
+	e.RLock()
+	defer e.RUnlock()
+	return e.leader
+}
+
+func (e *Elector) setLeader(leader bool){
+	e.Lock()
+	changed := e.leader != leader
+	e.leader = leader
+	onChange := e.onChange
+	e.Unlock()
+	if changed{
+		log.Printf("node %s leader changed to %v\n",e.id,leader)
+		if onChange != nil{
+			onChange(leader)
+		}
+	}
+}
+
+func (e *Elector) elect(){
+	leader,err := e.locker.TryLock(e.id,e.ttl)
+	if err != nil{
+		log.Println("node "+e.id+" try lock error "+err.Error())
+		leader = false
+	}
+	e.setLeader(leader)
+}
+
+//开始选主，第一次选主完成后返回，leader状态变化时调用onChange
+func (e *Elector) Run(onChange func(isLeader bool)){
+	e.Lock()
+	e.onChange = onChange
+	e.Unlock()
+	e.elect()
+	go func(){
+		ticker := time.NewTicker(e.ttl/3)
+		defer ticker.Stop()
+		for{
+			select{
+			case <-e.stopChan:
+				return
+			case <-ticker.C:
+				e.elect()
+			}
+		}
+	}()
+}
+
+//停止选主并主动释放锁，其他节点可以立即成为leader
+func (e *Elector) Stop(){
+	e.stopChan<-1
+	if e.IsLeader(){
+		if err := e.locker.Unlock(e.id);err != nil{
+			log.Println("node "+e.id+" unlock error "+err.Error())
+		}
+	}
+	e.setLeader(false)
+}
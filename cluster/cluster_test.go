@@ -0,0 +1,114 @@
+package cluster
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileLocker(test *testing.T){
+	dir,err := ioutil.TempDir("","cluster")
+	if err != nil{
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fl,err := NewFileLocker(filepath.Join(dir,"leader.lock"))
+	if err != nil{
+		test.Fatal(err)
+	}
+	if ok,err := fl.TryLock("node1",time.Second);!ok || err != nil{
+		test.Fatal("node1 should get the lock")
+	}
+	if ok,_ := fl.TryLock("node2",time.Second);ok{
+		test.Error("node2 should not get the lock held by node1")
+	}
+	if ok,_ := fl.TryLock("node1",time.Second);!ok{
+		test.Error("node1 should renew the lock")
+	}
+	if err := fl.Unlock("node1");err != nil{
+		test.Fatal(err)
+	}
+	if ok,_ := fl.TryLock("node2",50*time.Millisecond);!ok{
+		test.Error("node2 should get the released lock")
+	}
+	time.Sleep(100*time.Millisecond)
+	if ok,_ := fl.TryLock("node1",time.Second);!ok{
+		test.Error("node1 should get the expired lock")
+	}
+}
+
+//两个节点同时抢空的或者过期的租约，只有一个成功
+func TestFileLockerConcurrent(test *testing.T){
+	dir,err := ioutil.TempDir("","cluster")
+	if err != nil{
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir,"leader.lock")
+	for i := 0;i < 100;i++{
+		os.Remove(path)
+		if i%2 == 1{
+			expired,_ := NewFileLocker(path)
+			if ok,_ := expired.TryLock("node0",time.Nanosecond);!ok{
+				test.Fatal("node0 should get the lock")
+			}
+			time.Sleep(time.Millisecond)
+		}
+		results := make(chan bool,2)
+		start := make(chan int)
+		for _,id := range []string{"node1","node2"}{
+			go func(id string){
+				fl,_ := NewFileLocker(path)
+				<-start
+				ok,err := fl.TryLock(id,time.Minute)
+				if err != nil{
+					test.Error(err)
+				}
+				results<-ok
+			}(id)
+		}
+		close(start)
+		if first,second := <-results,<-results;first == second{
+			test.Fatalf("round %d: exactly one node should get the lock,got %v %v",i,first,second)
+		}
+	}
+}
+
+func TestElector(test *testing.T){
+	dir,err := ioutil.TempDir("","cluster")
+	if err != nil{
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fl,_ := NewFileLocker(filepath.Join(dir,"leader.lock"))
+	leader,_ := NewElector("node1",fl,time.Second)
+	follower,_ := NewElector("node2",fl,time.Second)
+	leader.Run(nil)
+	follower.Run(nil)
+	if !leader.IsLeader() || follower.IsLeader(){
+		test.Error("only node1 should be leader")
+	}
+
+	//leader主动释放后follower在下一次续期时成为leader
+	changed := make(chan bool,1)
+	follower.Lock()
+	follower.onChange = func(isLeader bool){
+		changed<-isLeader
+	}
+	follower.Unlock()
+	leader.Stop()
+	select{
+	case isLeader := <-changed:
+		if !isLeader{
+			test.Error("node2 should become leader")
+		}
+	case <-time.After(2*time.Second):
+		test.Error("node2 not become leader after node1 stopped")
+	}
+	follower.Stop()
+}
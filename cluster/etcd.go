@@ -0,0 +1,129 @@
+package cluster
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/tidwall/gjson"
+	"github.com/valyala/fasthttp"
+	"strings"
+	"sync"
+	"time"
+)
+
+//etcd租约锁，使用etcd v3的http json网关，不需要引入grpc客户端
+//key绑定到租约上，通过keepalive续期，租约过期后key自动删除
+type EtcdLocker struct {
+	locker   sync.Mutex
+	endpoint string
+	key      string
+	leaseID  string
+}
+
+func NewEtcdLocker(endpoint,key string) (*EtcdLocker,error){
+	if endpoint == ""{
+		return nil,errors.New("etcd locker need an endpoint")
+	}
+	if key == ""{
+		key = DEFAULT_LOCK_KEY
+	}
+	return &EtcdLocker{
+		endpoint:strings.TrimRight(endpoint,"/"),
+		key:key,
+	},nil
+}
+
+func (el *EtcdLocker) post(path string,param interface{}) (gjson.Result,error){
+	body,err := json.Marshal(param)
+	if err != nil{
+		return gjson.Result{},err
+	}
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI(el.endpoint+path)
+	req.Header.SetMethod("POST")
+	req.Header.SetContentType("application/json")
+	req.SetBody(body)
+	if err := fasthttp.DoTimeout(req,resp,3*time.Second);err != nil{
+		return gjson.Result{},err
+	}
+	result := gjson.ParseBytes(resp.Body())
+	if result.Get("error").Exists(){
+		return result,errors.New("etcd error "+result.Get("error").String())
+	}
+	return result,nil
+}
+
+func b64(value string) string{
+	return base64.StdEncoding.EncodeToString([]byte(value))
+}
+
+func (el *EtcdLocker) TryLock(id string,ttl time.Duration) (bool,error){
+	el.locker.Lock()
+	defer el.locker.Unlock()
+	//已有租约时先续期，租约过期则重新申请
+	if el.leaseID != ""{
+		result,err := el.post("/v3/lease/keepalive",map[string]string{"ID":el.leaseID})
+		if err != nil{
+			return false,err
+		}
+		if result.Get("result.TTL").Int() <= 0{
+			el.leaseID = ""
+		}
+	}
+	if el.leaseID == ""{
+		seconds := int64(ttl/time.Second)
+		if seconds < 1{
+			seconds = 1
+		}
+		result,err := el.post("/v3/lease/grant",map[string]int64{"TTL":seconds})
+		if err != nil{
+			return false,err
+		}
+		el.leaseID = result.Get("ID").String()
+	}
+
+	//key不存在时写入并绑定租约，否则读取当前持有者
+	result,err := el.post("/v3/kv/txn",map[string]interface{}{
+		"compare":[]map[string]string{{
+			"key":b64(el.key),
+			"target":"CREATE",
+			"create_revision":"0",
+		}},
+		"success":[]map[string]interface{}{{
+			"request_put":map[string]string{
+				"key":b64(el.key),
+				"value":b64(id),
+				"lease":el.leaseID,
+			},
+		}},
+		"failure":[]map[string]interface{}{{
+			"request_range":map[string]string{
+				"key":b64(el.key),
+			},
+		}},
+	})
+	if err != nil{
+		return false,err
+	}
+	if result.Get("succeeded").Bool(){
+		return true,nil
+	}
+	kv := result.Get("responses.0.response_range.kvs.0")
+	owner,_ := base64.StdEncoding.DecodeString(kv.Get("value").String())
+	return string(owner) == id && kv.Get("lease").String() == el.leaseID,nil
+}
+
+//撤销租约，绑定的key随之删除
+func (el *EtcdLocker) Unlock(id string) error{
+	el.locker.Lock()
+	defer el.locker.Unlock()
+	if el.leaseID == ""{
+		return nil
+	}
+	_,err := el.post("/v3/lease/revoke",map[string]string{"ID":el.leaseID})
+	el.leaseID = ""
+	return err
+}
@@ -0,0 +1,135 @@
+package cluster
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//共享存储上的文件锁，文件内容为持有者id和过期时间，
+//网络文件系统上flock不可靠，所以使用租约的方式，租约文件通过link创建，文件已存在时失败，同时抢锁只有一个节点成功
+type FileLocker struct {
+	path string
+}
+
+func NewFileLocker(path string) (*FileLocker,error){
+	if path == ""{
+		return nil,errors.New("file locker need a file path")
+	}
+	return &FileLocker{path:path},nil
+}
+
+func (fl *FileLocker) read() (string,time.Time,error){
+	content,err := ioutil.ReadFile(fl.path)
+	if err != nil{
+		return "",time.Time{},err
+	}
+	parts := strings.SplitN(strings.TrimSpace(string(content)),"\n",2)
+	if len(parts) != 2{
+		//内容不完整，视为已过期
+		return "",time.Time{},nil
+	}
+	expireAt,err := strconv.ParseInt(parts[1],10,64)
+	if err != nil{
+		return "",time.Time{},nil
+	}
+	return parts[0],time.Unix(0,expireAt),nil
+}
+
+func (fl *FileLocker) writeTmp(id string,ttl time.Duration) (string,error){
+	content := id+"\n"+strconv.FormatInt(time.Now().Add(ttl).UnixNano(),10)
+	tmpFile := fl.path+"."+id+".tmp"
+	return tmpFile,ioutil.WriteFile(tmpFile,[]byte(content),0644)
+}
+
+//租约文件不存在时创建，已存在时返回false
+func (fl *FileLocker) create(id string,ttl time.Duration) (bool,error){
+	tmpFile,err := fl.writeTmp(id,ttl)
+	defer os.Remove(tmpFile)
+	if err != nil{
+		return false,err
+	}
+	if err := os.Link(tmpFile,fl.path);err != nil{
+		if os.IsExist(err){
+			return false,nil
+		}
+		return false,err
+	}
+	return true,nil
+}
+
+//持有者在租约过期前续期
+func (fl *FileLocker) renew(id string,ttl time.Duration) error{
+	tmpFile,err := fl.writeTmp(id,ttl)
+	if err != nil{
+		os.Remove(tmpFile)
+		return err
+	}
+	return os.Rename(tmpFile,fl.path)
+}
+
+//接管过期的租约，按过期时间排他创建标记文件，只有创建成功的节点可以删除过期的租约文件
+func (fl *FileLocker) takeover(id,owner string,expireAt time.Time,ttl time.Duration) (bool,error){
+	marker := fl.path+"."+strconv.FormatInt(expireAt.UnixNano(),10)+".takeover"
+	file,err := os.OpenFile(marker,os.O_CREATE|os.O_EXCL|os.O_WRONLY,0644)
+	if err != nil{
+		if os.IsExist(err){
+			return false,nil
+		}
+		return false,err
+	}
+	file.Close()
+	//新的租约写入后租约文件已经变化，删除标记文件后其他节点也无法再删除
+	defer os.Remove(marker)
+	current,currentExpireAt,err := fl.read()
+	if err == nil{
+		//持有者已经续期
+		if current != owner || !currentExpireAt.Equal(expireAt){
+			return false,nil
+		}
+		if err := os.Remove(fl.path);err != nil && !os.IsNotExist(err){
+			return false,err
+		}
+	}else if !os.IsNotExist(err){
+		return false,err
+	}
+	return fl.create(id,ttl)
+}
+
+func (fl *FileLocker) TryLock(id string,ttl time.Duration) (bool,error){
+	owner,expireAt,err := fl.read()
+	if os.IsNotExist(err){
+		return fl.create(id,ttl)
+	}
+	if err != nil{
+		return false,err
+	}
+	if time.Now().Before(expireAt){
+		if owner != id{
+			return false,nil
+		}
+		if err := fl.renew(id,ttl);err != nil{
+			return false,err
+		}
+		return true,nil
+	}
+	return fl.takeover(id,owner,expireAt,ttl)
+}
+
+func (fl *FileLocker) Unlock(id string) error{
+	owner,expireAt,err := fl.read()
+	if err != nil{
+		if os.IsNotExist(err){
+			return nil
+		}
+		return err
+	}
+	//已过期的租约可能正在被其他节点接管
+	if owner != id || !time.Now().Before(expireAt){
+		return nil
+	}
+	return os.Remove(fl.path)
+}
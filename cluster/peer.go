@@ -0,0 +1,138 @@
+package cluster
+
+import (
+	"errors"
+	"github.com/valyala/fasthttp"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	PEER_STATE_PATH = "/internal/tokens"
+	PEER_TOKEN_HEADER = "X-Peer-Token"
+	DEFAULT_SYNC_INTERVAL = 5*time.Second
+	DEFAULT_GRACE_PERIOD = 5*time.Minute
+)
+
+//需要在节点间同步的状态，leader导出，follower导入
+type PeerState interface {
+	ExportPeerState() ([]byte,error)
+	ImportPeerState(state []byte) error
+}
+
+//follower定时从leader拉取状态，leader超过宽限期无法访问时，follower自行刷新
+type PeerSyncer struct {
+	sync.RWMutex
+	elector     *Elector
+	peers       []string
+	token       string
+	interval    time.Duration
+	grace       time.Duration
+	state       PeerState
+	lastContact time.Time
+	stopChan    chan int
+}
+
+func NewPeerSyncer(elector *Elector,state PeerState,peers []string,token string,interval,grace time.Duration) (*PeerSyncer,error){
+	if elector == nil || state == nil{
+		return nil,errors.New("peer syncer need elector and state")
+	}
+	if token == ""{
+		return nil,errors.New("peer syncer need a peer token")
+	}
+	if interval <= 0{
+		interval = DEFAULT_SYNC_INTERVAL
+	}
+	if grace <= 0{
+		grace = DEFAULT_GRACE_PERIOD
+	}
+	return &PeerSyncer{
+		elector:elector,
+		peers:peers,
+		token:token,
+		interval:interval,
+		grace:grace,
+		state:state,
+		lastContact:time.Now(),
+		stopChan:make(chan int,1),
+	},nil
+}
+
+//选主成功，或者leader超过宽限期无法访问时，当前节点需要自行请求微信接口
+func (ps *PeerSyncer) IsLeader() bool{
+	if ps.elector.IsLeader(){
+		return true
+	}
+	ps.RLock()
+	defer ps.RUnlock()
+	return time.Since(ps.lastContact) > ps.grace
+}
+
+func (ps *PeerSyncer) LastContact() time.Time{
+	ps.RLock()
+	defer ps.RUnlock()
+	return ps.lastContact
+}
+
+//依次请求所有peer，只有leader会返回状态，其他节点返回503
+func (ps *PeerSyncer) sync() error{
+	var lastErr error
+	for _,peer := range ps.peers{
+		req := fasthttp.AcquireRequest()
+		resp := fasthttp.AcquireResponse()
+		req.SetRequestURI(strings.TrimRight(peer,"/")+PEER_STATE_PATH)
+		req.Header.Set(PEER_TOKEN_HEADER,ps.token)
+		err := fasthttp.DoTimeout(req,resp,3*time.Second)
+		if err == nil && resp.StatusCode() != fasthttp.StatusOK{
+			err = errors.New(peer+" response status "+strconv.Itoa(resp.StatusCode()))
+		}
+		if err == nil{
+			err = ps.state.ImportPeerState(resp.Body())
+		}
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(resp)
+		if err != nil{
+			lastErr = err
+			continue
+		}
+		ps.Lock()
+		ps.lastContact = time.Now()
+		ps.Unlock()
+		return nil
+	}
+	if lastErr == nil{
+		lastErr = errors.New("no peer configured")
+	}
+	return lastErr
+}
+
+func (ps *PeerSyncer) Run(){
+	go func(){
+		ticker := time.NewTicker(ps.interval)
+		defer ticker.Stop()
+		for{
+			select{
+			case <-ps.stopChan:
+				return
+			case <-ticker.C:
+				if ps.elector.IsLeader(){
+					//leader自身不需要同步，同时重置宽限期，失去leader后重新计算
+					ps.Lock()
+					ps.lastContact = time.Now()
+					ps.Unlock()
+					continue
+				}
+				if err := ps.sync();err != nil{
+					log.Println("peer sync error "+err.Error())
+				}
+			}
+		}
+	}()
+}
+
+func (ps *PeerSyncer) Stop(){
+	ps.stopChan<-1
+}
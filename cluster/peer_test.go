@@ -0,0 +1,68 @@
+package cluster
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testPeerState struct {
+	state []byte
+}
+
+func (tps *testPeerState) ExportPeerState() ([]byte,error){
+	return tps.state,nil
+}
+
+func (tps *testPeerState) ImportPeerState(state []byte) error{
+	tps.state = state
+	return nil
+}
+
+func TestPeerSyncer(test *testing.T){
+	follower := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,r *http.Request){
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer follower.Close()
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,r *http.Request){
+		if r.URL.Path != PEER_STATE_PATH || r.Header.Get(PEER_TOKEN_HEADER) != "peer"{
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("leader state"))
+	}))
+	defer leader.Close()
+
+	dir,err := ioutil.TempDir("","cluster")
+	if err != nil{
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fl,_ := NewFileLocker(filepath.Join(dir,"leader.lock"))
+	fl.TryLock("other",time.Minute)
+	elector,_ := NewElector("node",fl,time.Minute)
+	elector.elect()
+
+	state := &testPeerState{}
+	ps,err := NewPeerSyncer(elector,state,[]string{follower.URL,leader.URL},"peer",time.Second,100*time.Millisecond)
+	if err != nil{
+		test.Fatal(err)
+	}
+	if err := ps.sync();err != nil{
+		test.Fatal("sync error "+err.Error())
+	}
+	if string(state.state) != "leader state"{
+		test.Error("state should be synced from leader")
+	}
+	if ps.IsLeader(){
+		test.Error("follower should not refresh while leader reachable")
+	}
+	time.Sleep(150*time.Millisecond)
+	if !ps.IsLeader(){
+		test.Error("follower should refresh after grace period")
+	}
+}
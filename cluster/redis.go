@@ -0,0 +1,52 @@
+package cluster
+
+import (
+	"github.com/dbldqt/wechatTokenServer/resp"
+	"strconv"
+	"time"
+)
+
+const (
+	//持有者为自己时续期
+	REDIS_RENEW_SCRIPT = `if redis.call("get",KEYS[1]) == ARGV[1] then return redis.call("pexpire",KEYS[1],ARGV[2]) else return 0 end`
+	//持有者为自己时删除
+	REDIS_UNLOCK_SCRIPT = `if redis.call("get",KEYS[1]) == ARGV[1] then return redis.call("del",KEYS[1]) else return 0 end`
+)
+
+//redis锁，SET NX PX获取锁，lua脚本续期和释放
+type RedisLocker struct {
+	client *resp.Client
+	key    string
+}
+
+func NewRedisLocker(client *resp.Client,key string) *RedisLocker{
+	if key == ""{
+		key = DEFAULT_LOCK_KEY
+	}
+	return &RedisLocker{
+		client:client,
+		key:key,
+	}
+}
+
+func (rl *RedisLocker) TryLock(id string,ttl time.Duration) (bool,error){
+	ms := strconv.FormatInt(int64(ttl/time.Millisecond),10)
+	reply,err := rl.client.Do("SET",rl.key,id,"NX","PX",ms)
+	if err != nil{
+		return false,err
+	}
+	if reply == "OK"{
+		return true,nil
+	}
+	reply,err = rl.client.Do("EVAL",REDIS_RENEW_SCRIPT,"1",rl.key,id,ms)
+	if err != nil{
+		return false,err
+	}
+	renewed,_ := reply.(int64)
+	return renewed == 1,nil
+}
+
+func (rl *RedisLocker) Unlock(id string) error{
+	_,err := rl.client.Do("EVAL",REDIS_UNLOCK_SCRIPT,"1",rl.key,id)
+	return err
+}
@@ -4,24 +4,56 @@ import (
 	"github.com/BurntSushi/toml"
 	"io/ioutil"
 	"errors"
+	"strings"
 	"sync"
+	"time"
+	"github.com/dbldqt/wechatTokenServer/cluster"
+	"github.com/dbldqt/wechatTokenServer/secure"
 	"github.com/dbldqt/wechatTokenServer/wechat"
 )
 
 var configMan ConfigMan
 var once sync.Once
 
+//多实例部署时的选主配置
+type LeaderConfig struct {
+	Type string          //锁类型，file，redis，etcd，为空时不选主
+	NodeID string        //节点id，为空时使用主机名+进程id
+	LockFile string      //file锁使用的共享存储上的文件
+	LockKey string       //redis和etcd锁使用的key
+	LockTTL int          //锁的有效期，秒
+	EtcdEndpoint string  //etcd地址，例如http://127.0.0.1:2379
+	Peers []string       //所有节点的地址，follower从leader同步accessToken，为空时从TokenStore同步
+	PeerToken string     //节点间同步的认证token
+	SyncInterval int     //follower同步间隔，秒
+	GracePeriod int      //leader无法访问超过该时间后follower自行刷新，秒
+}
+
 type Config struct {
 	sync.RWMutex
 	Port int
+	GrpcPort int  //grpc接口端口，为0时不启用
 	Wechat []*wechat.WechatConfig
 	AheadTime int
 	LoopTime int
 	LogFile string
+	SnapshotFile string
+	TokenStore string
+	TokenStoreFile string
+	RedisAddr string
+	RedisPassword string
+	RedisDB int
+	RedisKeyTemplate string
+	Leader LeaderConfig
 	UseIpWhiteList bool
 	IpList []string
 	AdminIpList []string
 	AdminToken string
+	ApiBaseURL string      //微信接口地址，应用未单独配置时使用，为空时使用官方地址
+	WecomApiBaseURL string //企业微信接口地址
+	RetryMaxAttempts int   //刷新失败后最多连续尝试的次数，为0时使用默认值
+	RetryBaseDelay int     //第一次重试的间隔，秒，之后每次翻倍
+	RetryMaxDelay int      //最大重试间隔，秒
 }
 
 func (conf *Config) GetPort() int{
@@ -30,6 +62,12 @@ func (conf *Config) GetPort() int{
 	return conf.Port
 }
 
+func (conf *Config) GetGrpcPort() int{
+	defer conf.RUnlock()
+	conf.RLock()
+	return conf.GrpcPort
+}
+
 func (conf *Config) GetWechatConfigs() []*wechat.WechatConfig{
 	defer conf.RUnlock()
 	conf.RLock()
@@ -54,6 +92,30 @@ func (conf *Config) GetLogFile() string{
 	return conf.LogFile
 }
 
+func (conf *Config) GetSnapshotFile() string{
+	defer conf.RUnlock()
+	conf.RLock()
+	return conf.SnapshotFile
+}
+
+func (conf *Config) GetTokenStore() (string,string){
+	defer conf.RUnlock()
+	conf.RLock()
+	return conf.TokenStore,conf.TokenStoreFile
+}
+
+func (conf *Config) GetRedis() (string,string,int,string){
+	defer conf.RUnlock()
+	conf.RLock()
+	return conf.RedisAddr,conf.RedisPassword,conf.RedisDB,conf.RedisKeyTemplate
+}
+
+func (conf *Config) GetLeaderConfig() LeaderConfig{
+	defer conf.RUnlock()
+	conf.RLock()
+	return conf.Leader
+}
+
 func (conf *Config) GetIpList() []string{
 	defer conf.RUnlock()
 	conf.RLock()
@@ -66,6 +128,16 @@ func (conf *Config) GetAdminIpList() []string{
 	return conf.AdminIpList
 }
 
+func (conf *Config) GetRetryPolicy() wechat.RetryPolicy{
+	defer conf.RUnlock()
+	conf.RLock()
+	return wechat.RetryPolicy{
+		MaxAttempts:conf.RetryMaxAttempts,
+		BaseDelay:time.Duration(conf.RetryBaseDelay)*time.Second,
+		MaxDelay:time.Duration(conf.RetryMaxDelay)*time.Second,
+	}
+}
+
 func (conf *Config) GetAdminToken() string{
 	defer conf.RUnlock()
 	conf.RLock()
@@ -94,6 +166,50 @@ func GetConfigMan() *ConfigMan{
 	return &configMan
 }
 
+//解密配置文件中以enc:开头的敏感字段，需要先调用secure.LoadMasterKey加载主密钥
+func (conf *Config) decryptSecrets() error{
+	var err error
+	if conf.AdminToken,err = decryptField("AdminToken",conf.AdminToken);err != nil{
+		return err
+	}
+	if conf.RedisPassword,err = decryptField("RedisPassword",conf.RedisPassword);err != nil{
+		return err
+	}
+	if conf.Leader.PeerToken,err = decryptField("PeerToken",conf.Leader.PeerToken);err != nil{
+		return err
+	}
+	for _,wxconf := range conf.Wechat{
+		if wxconf.AppSecret,err = decryptField(wxconf.AppID+" AppSecret",wxconf.AppSecret);err != nil{
+			return err
+		}
+		if wxconf.EncodingAESKey,err = decryptField(wxconf.AppID+" EncodingAESKey",wxconf.EncodingAESKey);err != nil{
+			return err
+		}
+	}
+	return nil
+}
+
+func decryptField(name,value string) (string,error){
+	if !secure.IsEncrypted(value){
+		return value,nil
+	}
+	if !secure.HasMasterKey(){
+		return "",errors.New(name+" is encrypted but master key not set")
+	}
+	plain,err := secure.DecryptString(value)
+	if err != nil{
+		return "",errors.New("decrypt "+name+" error "+err.Error())
+	}
+	return plain,nil
+}
+
+func checkBaseURL(baseURL string) error{
+	if baseURL != "" && !strings.HasPrefix(baseURL,"http://") && !strings.HasPrefix(baseURL,"https://"){
+		return errors.New("api base url must start with http:// or https://")
+	}
+	return nil
+}
+
 func LoadConfig(configFile string) (*Config,error){
 	config := Config{}
 	fileContent,err := ioutil.ReadFile(configFile)
@@ -113,11 +229,64 @@ func LoadConfig(configFile string) (*Config,error){
 	if config.Port <= 0{
 		return nil,errors.New("port must be great than 0")
 	}
+	if config.GrpcPort < 0 || config.GrpcPort == config.Port{
+		return nil,errors.New("grpcPort must be 0 or different from port")
+	}
+
+	if config.RetryMaxAttempts < 0 || config.RetryBaseDelay < 0 || config.RetryMaxDelay < 0{
+		return nil,errors.New("retry config must not be negative")
+	}
 
 	if len(config.Wechat) == 0{
 		return nil,errors.New("must config one or more wechat info")
 	}
 
+	for _,baseURL := range []string{config.ApiBaseURL,config.WecomApiBaseURL}{
+		if err := checkBaseURL(baseURL);err != nil{
+			return nil,err
+		}
+	}
+	for _,wxconf := range config.Wechat{
+		if err := checkBaseURL(wxconf.ApiBaseURL);err != nil{
+			return nil,errors.New(wxconf.AppID+" "+err.Error())
+		}
+		if wxconf.ApiBaseURL == ""{
+			if wxconf.IsWecom(){
+				wxconf.ApiBaseURL = config.WecomApiBaseURL
+			}else{
+				wxconf.ApiBaseURL = config.ApiBaseURL
+			}
+		}
+		if wxconf.Provider != "" && wxconf.Provider != wechat.PROVIDER_WECHAT && wxconf.Provider != wechat.PROVIDER_WECOM{
+			return nil,errors.New("provider of "+wxconf.AppID+" must be wechat or wecom")
+		}
+		if wxconf.IsWecom() && wxconf.Type != ""{
+			return nil,errors.New("wecom app "+wxconf.AppID+" not support type "+wxconf.Type)
+		}
+		if wxconf.IsWecom() && (wxconf.JsapiTicket || wxconf.CardTicket){
+			return nil,errors.New("wecom app "+wxconf.AppID+" not support jsapiTicket or cardTicket")
+		}
+		if wxconf.StableToken && (wxconf.IsWecom() || wxconf.Type != ""){
+			return nil,errors.New("stable token only support wechat official account or mini program, check "+wxconf.AppID)
+		}
+	}
+
+	if err := config.decryptSecrets();err != nil{
+		return nil,err
+	}
+
+	switch config.Leader.Type{
+	case "",cluster.LOCK_FILE,cluster.LOCK_REDIS,cluster.LOCK_ETCD:
+	default:
+		return nil,errors.New("leader lock type must be file, redis or etcd")
+	}
+	if config.Leader.Type == cluster.LOCK_REDIS && config.RedisAddr == ""{
+		return nil,errors.New("redis leader lock need RedisAddr")
+	}
+	if len(config.Leader.Peers) > 0 && config.Leader.PeerToken == ""{
+		return nil,errors.New("peer sync need PeerToken")
+	}
+
 	if config.UseIpWhiteList && (len(config.IpList) == 0 || config.IpList == nil){
 		config.IpList = append(config.IpList,"127.0.0.1")
 	}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/valyala/fasthttp"
+	"log"
+	"strconv"
+	"time"
+	"github.com/dbldqt/wechatTokenServer/wechat"
+)
+
+const (
+	SSE_HEARTBEAT = 15*time.Second
+	SSE_RETRY = 3000 //客户端断线重连间隔，毫秒
+)
+
+//GET /v1/apps/{appid}/events，使用Server-Sent Events推送accessToken变化
+//断线重连时客户端携带Last-Event-ID，补发期间错过的事件，错过太多时先推送当前accessToken
+func eventStreamHandler(ctx *fasthttp.RequestCtx,appid string){
+	wechatman,ok := getWechatMan(ctx)
+	if !ok{
+		return
+	}
+	current,err := wechatman.CurrentTokenEvent(appid,appToken(ctx))
+	if err != nil{
+		log.Println(appid+" subscribe events error "+err.Error())
+		replyError(ctx,0,setWechatError(ctx,err),err.Error())
+		return
+	}
+	lastEventID := string(ctx.Request.Header.Peek("Last-Event-ID"))
+	if lastEventID == ""{
+		lastEventID = string(ctx.QueryArgs().Peek("lastEventId"))
+	}
+	var lastID uint64
+	if lastEventID != ""{
+		if lastID,err = strconv.ParseUint(lastEventID,10,64);err != nil{
+			replyError(ctx,fasthttp.StatusBadRequest,ERRCODE_BAD_REQUEST,"Last-Event-ID error")
+			return
+		}
+	}
+	sub,backlog,complete := wechat.GetEventBus().SubscribeSince(lastID,current.Key())
+	log.Println(ctx.RemoteIP().String()+" subscribe "+appid+" events from "+strconv.FormatUint(lastID,10))
+
+	ctx.SetContentType("text/event-stream")
+	ctx.Response.Header.Set("Cache-Control","no-cache")
+	ctx.Response.Header.Set("X-Accel-Buffering","no")
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer){
+		defer sub.Close()
+		w.WriteString("retry: "+strconv.Itoa(SSE_RETRY)+"\n\n")
+		if !complete && current.AccessToken != ""{
+			writeSSE(w,current)
+		}
+		for _,event := range backlog{
+			writeSSE(w,event)
+		}
+		if w.Flush() != nil{
+			return
+		}
+		heartbeat := time.NewTicker(SSE_HEARTBEAT)
+		defer heartbeat.Stop()
+		for{
+			select{
+			case event,ok := <-sub.C:
+				//订阅被关闭，结束连接由客户端重连补发
+				if !ok{
+					return
+				}
+				writeSSE(w,event)
+			case <-heartbeat.C:
+				w.WriteString(": heartbeat\n\n")
+			}
+			if w.Flush() != nil{
+				log.Println(appid+" events subscriber disconnected")
+				return
+			}
+		}
+	})
+}
+
+//ID为0的事件是补发的当前状态，不写id，避免覆盖客户端的Last-Event-ID
+func writeSSE(w *bufio.Writer,event *wechat.Event){
+	data,err := json.Marshal(event)
+	if err != nil{
+		log.Println("marshal event error "+err.Error())
+		return
+	}
+	if event.ID > 0{
+		w.WriteString("id: "+strconv.FormatUint(event.ID,10)+"\n")
+	}
+	w.WriteString("event: "+event.Type+"\n")
+	w.WriteString("data: ")
+	w.Write(data)
+	w.WriteString("\n\n")
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/dbldqt/wechatTokenServer/wechat"
+	"strings"
+	"testing"
+)
+
+func TestWriteSSE(test *testing.T){
+	buf := &bytes.Buffer{}
+	w := bufio.NewWriter(buf)
+	writeSSE(w,&wechat.Event{ID:3,Type:wechat.EVENT_TOKEN_ROTATED,AppID:"wx",AccessToken:"token"})
+	writeSSE(w,&wechat.Event{Type:wechat.EVENT_TOKEN_ROTATED,AppID:"wx",AccessToken:"current"})
+	w.Flush()
+	events := strings.Split(strings.TrimSuffix(buf.String(),"\n\n"),"\n\n")
+	if len(events) != 2{
+		test.Fatal("event count error",buf.String())
+	}
+	if !strings.HasPrefix(events[0],"id: 3\nevent: token_rotated\ndata: {") || !strings.Contains(events[0],`"accessToken":"token"`){
+		test.Error("event format error",events[0])
+	}
+	if strings.Contains(events[1],"id:"){
+		test.Error("current state event should not have id",events[1])
+	}
+}
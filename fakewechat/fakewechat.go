@@ -0,0 +1,231 @@
+package fakewechat
+
+import (
+	"encoding/json"
+	"github.com/valyala/fasthttp"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//模拟微信accessToken接口的服务，用于离线测试，WechatConfig.ApiBaseURL配置为URL()即可
+//支持/cgi-bin/token，/cgi-bin/stable_token，企业微信/cgi-bin/gettoken和/cgi-bin/ticket/getticket，
+//其他路径校验access_token后返回errcode 0
+
+const (
+	DEFAULT_EXPIRES_IN = 7200
+	DEFAULT_ROTATE_WINDOW = 300 //stable_token普通模式在accessToken最后5分钟内调用才返回新的accessToken
+)
+
+var errmsgs = map[int]string{
+	-1:"system error",
+	0:"ok",
+	40001:"invalid credential, access_token is invalid or not latest",
+	40013:"invalid appid",
+	40125:"invalid appsecret",
+	40164:"invalid ip, not in whitelist",
+	42001:"access_token expired",
+	45009:"reach max api daily quota limit",
+}
+
+type token struct {
+	appid    string
+	expireAt time.Time
+	rotated  bool //stable_token普通模式轮换后，旧的accessToken在过期前仍然有效
+}
+
+type Server struct {
+	sync.Mutex
+	listener  net.Listener
+	server    *fasthttp.Server
+	expiresIn int
+	rotateWindow int
+	apps      map[string]string //appid或corpid对应的secret
+	current   map[string]string //每个应用当前有效的accessToken
+	tokens    map[string]*token
+	errcodes  []int             //依次返回给之后的accessToken请求
+	requests  map[string]int    //每个应用请求accessToken的次数
+	seq       int
+}
+
+//在127.0.0.1的随机端口启动服务
+func NewServer() (*Server,error){
+	listener,err := net.Listen("tcp","127.0.0.1:0")
+	if err != nil{
+		return nil,err
+	}
+	s := &Server{
+		listener:listener,
+		expiresIn:DEFAULT_EXPIRES_IN,
+		rotateWindow:DEFAULT_ROTATE_WINDOW,
+		apps:make(map[string]string),
+		current:make(map[string]string),
+		tokens:make(map[string]*token),
+		requests:make(map[string]int),
+	}
+	//不保持连接，Close时不需要等待空闲连接超时
+	s.server = &fasthttp.Server{Handler:s.handler,DisableKeepalive:true}
+	go s.server.Serve(listener)
+	return s,nil
+}
+
+func (s *Server) URL() string{
+	return "http://"+s.listener.Addr().String()
+}
+
+func (s *Server) Close() error{
+	return s.server.Shutdown()
+}
+
+//添加应用，企业微信使用corpid
+func (s *Server) AddApp(appid,secret string){
+	s.Lock()
+	s.apps[appid] = secret
+	s.Unlock()
+}
+
+//之后颁发的accessToken的有效期，秒
+func (s *Server) SetExpiresIn(expiresIn int){
+	s.Lock()
+	s.expiresIn = expiresIn
+	s.Unlock()
+}
+
+//stable_token普通模式返回新accessToken的时间窗口，秒
+func (s *Server) SetRotateWindow(rotateWindow int){
+	s.Lock()
+	s.rotateWindow = rotateWindow
+	s.Unlock()
+}
+
+//之后的accessToken请求依次返回这些errcode，返回完后恢复正常
+func (s *Server) FailNext(errcodes ...int){
+	s.Lock()
+	s.errcodes = append(s.errcodes,errcodes...)
+	s.Unlock()
+}
+
+//应用当前有效的accessToken
+func (s *Server) AccessToken(appid string) string{
+	s.Lock()
+	defer s.Unlock()
+	return s.current[appid]
+}
+
+//应用请求accessToken的次数
+func (s *Server) Requests(appid string) int{
+	s.Lock()
+	defer s.Unlock()
+	return s.requests[appid]
+}
+
+//使应用当前的accessToken失效，模拟其他调用方获取了新的accessToken
+func (s *Server) Invalidate(appid string){
+	s.Lock()
+	delete(s.current,appid)
+	s.Unlock()
+}
+
+func (s *Server) handler(ctx *fasthttp.RequestCtx){
+	args := ctx.QueryArgs()
+	switch string(ctx.Path()){
+	case "/cgi-bin/token":
+		s.issueToken(ctx,string(args.Peek("appid")),string(args.Peek("secret")),false)
+	case "/cgi-bin/gettoken":
+		s.issueToken(ctx,string(args.Peek("corpid")),string(args.Peek("corpsecret")),false)
+	case "/cgi-bin/stable_token":
+		param := struct{
+			AppID        string `json:"appid"`
+			Secret       string `json:"secret"`
+			ForceRefresh bool   `json:"force_refresh"`
+		}{}
+		if err := json.Unmarshal(ctx.PostBody(),&param);err != nil{
+			replyErrcode(ctx,-1)
+			return
+		}
+		s.issueToken(ctx,param.AppID,param.Secret,!param.ForceRefresh)
+	case "/cgi-bin/ticket/getticket":
+		if !s.checkToken(ctx){
+			return
+		}
+		s.Lock()
+		s.seq++
+		ticket := "TICKET_"+string(args.Peek("type"))+"_"+strconv.Itoa(s.seq)
+		s.Unlock()
+		replyJson(ctx,map[string]interface{}{"errcode":0,"errmsg":"ok","ticket":ticket,"expires_in":DEFAULT_EXPIRES_IN})
+	default:
+		if s.checkToken(ctx){
+			replyErrcode(ctx,0)
+		}
+	}
+}
+
+//stable为true时模拟stable_token普通模式，剩余有效期大于rotateWindow时返回当前的accessToken，
+//否则返回新的accessToken，旧的accessToken在过期前仍然有效，其他情况颁发新的accessToken并使旧的失效
+func (s *Server) issueToken(ctx *fasthttp.RequestCtx,appid,secret string,stable bool){
+	s.Lock()
+	defer s.Unlock()
+	s.requests[appid]++
+	if len(s.errcodes) > 0{
+		errcode := s.errcodes[0]
+		s.errcodes = s.errcodes[1:]
+		replyErrcode(ctx,errcode)
+		return
+	}
+	appSecret,ok := s.apps[appid]
+	if !ok{
+		replyErrcode(ctx,40013)
+		return
+	}
+	if appSecret != secret{
+		replyErrcode(ctx,40125)
+		return
+	}
+	now := time.Now()
+	if current,ok := s.tokens[s.current[appid]];ok && stable && now.Before(current.expireAt){
+		if current.expireAt.Sub(now) > time.Duration(s.rotateWindow)*time.Second{
+			replyJson(ctx,map[string]interface{}{"access_token":s.current[appid],"expires_in":int(current.expireAt.Sub(now).Seconds())})
+			return
+		}
+		current.rotated = true
+	}
+	s.seq++
+	accessToken := "ACCESS_TOKEN_"+appid+"_"+strconv.Itoa(s.seq)
+	s.current[appid] = accessToken
+	s.tokens[accessToken] = &token{appid:appid,expireAt:now.Add(time.Duration(s.expiresIn)*time.Second)}
+	replyJson(ctx,map[string]interface{}{"access_token":accessToken,"expires_in":s.expiresIn})
+}
+
+//被替换的accessToken返回40001，过期的返回42001
+func (s *Server) checkToken(ctx *fasthttp.RequestCtx) bool{
+	accessToken := string(ctx.QueryArgs().Peek("access_token"))
+	s.Lock()
+	t,ok := s.tokens[accessToken]
+	valid := ok && (s.current[t.appid] == accessToken || t.rotated)
+	expired := ok && time.Now().After(t.expireAt)
+	s.Unlock()
+	switch{
+	case !valid:
+		replyErrcode(ctx,40001)
+	case expired:
+		replyErrcode(ctx,42001)
+	default:
+		return true
+	}
+	return false
+}
+
+func replyErrcode(ctx *fasthttp.RequestCtx,errcode int){
+	errmsg,ok := errmsgs[errcode]
+	if !ok{
+		errmsg = "unknown error"
+	}
+	replyJson(ctx,map[string]interface{}{"errcode":errcode,"errmsg":errmsg})
+}
+
+func replyJson(ctx *fasthttp.RequestCtx,result interface{}){
+	data,_ := json.Marshal(result)
+	ctx.SetContentType("application/json; encoding=utf-8")
+	ctx.SetBody(data)
+}
@@ -0,0 +1,97 @@
+package fakewechat
+
+import (
+	"github.com/tidwall/gjson"
+	"github.com/valyala/fasthttp"
+	"testing"
+)
+
+func get(test *testing.T,url string) gjson.Result{
+	_,body,err := fasthttp.Get(nil,url)
+	if err != nil{
+		test.Fatal(err)
+	}
+	return gjson.ParseBytes(body)
+}
+
+func TestServer(test *testing.T){
+	s,err := NewServer()
+	if err != nil{
+		test.Fatal(err)
+	}
+	defer s.Close()
+	s.AddApp("wx_fake","secret")
+	s.SetExpiresIn(60)
+
+	if errcode := get(test,s.URL()+"/cgi-bin/token?appid=wx_fake&secret=wrong").Get("errcode").Int();errcode != 40125{
+		test.Error("wrong secret errcode",errcode)
+	}
+	first := get(test,s.URL()+"/cgi-bin/token?appid=wx_fake&secret=secret")
+	if first.Get("access_token").String() != s.AccessToken("wx_fake") || first.Get("expires_in").Int() != 60{
+		test.Error("issue token error",first.Raw)
+	}
+	second := get(test,s.URL()+"/cgi-bin/token?appid=wx_fake&secret=secret")
+	if errcode := get(test,s.URL()+"/cgi-bin/menu/get?access_token="+first.Get("access_token").String()).Get("errcode").Int();errcode != 40001{
+		test.Error("replaced token errcode",errcode)
+	}
+	if errcode := get(test,s.URL()+"/cgi-bin/menu/get?access_token="+second.Get("access_token").String()).Get("errcode").Int();errcode != 0{
+		test.Error("current token errcode",errcode)
+	}
+
+	s.FailNext(-1,45009)
+	for _,want := range []int64{-1,45009}{
+		if errcode := get(test,s.URL()+"/cgi-bin/token?appid=wx_fake&secret=secret").Get("errcode").Int();errcode != want{
+			test.Errorf("injected errcode %d,want %d",errcode,want)
+		}
+	}
+	if s.AccessToken("wx_fake") != second.Get("access_token").String() || s.Requests("wx_fake") != 5{
+		test.Error("failed requests should not change token",s.Requests("wx_fake"))
+	}
+	s.Invalidate("wx_fake")
+	if errcode := get(test,s.URL()+"/cgi-bin/ticket/getticket?type=jsapi&access_token="+second.Get("access_token").String()).Get("errcode").Int();errcode != 40001{
+		test.Error("invalidated token errcode",errcode)
+	}
+}
+
+func TestStableToken(test *testing.T){
+	s,err := NewServer()
+	if err != nil{
+		test.Fatal(err)
+	}
+	defer s.Close()
+	s.AddApp("wx_stable","secret")
+	post := func(body string) string{
+		req := fasthttp.AcquireRequest()
+		resp := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseRequest(req)
+		defer fasthttp.ReleaseResponse(resp)
+		req.SetRequestURI(s.URL()+"/cgi-bin/stable_token")
+		req.Header.SetMethod("POST")
+		req.SetBodyString(body)
+		if err := fasthttp.Do(req,resp);err != nil{
+			test.Fatal(err)
+		}
+		return gjson.GetBytes(resp.Body(),"access_token").String()
+	}
+	first := post(`{"grant_type":"client_credential","appid":"wx_stable","secret":"secret"}`)
+	if first == "" || post(`{"grant_type":"client_credential","appid":"wx_stable","secret":"secret"}`) != first{
+		test.Error("stable token should not change")
+	}
+	forced := post(`{"grant_type":"client_credential","appid":"wx_stable","secret":"secret","force_refresh":true}`)
+	if forced == first{
+		test.Error("force refresh should issue new token")
+	}
+	if errcode := get(test,s.URL()+"/cgi-bin/menu/get?access_token="+first).Get("errcode").Int();errcode != 40001{
+		test.Error("force refresh should invalidate old token",errcode)
+	}
+
+	//剩余有效期在轮换窗口内时普通模式返回新的accessToken，旧的在过期前仍然有效
+	s.SetRotateWindow(DEFAULT_EXPIRES_IN)
+	rotated := post(`{"grant_type":"client_credential","appid":"wx_stable","secret":"secret"}`)
+	if rotated == forced || rotated != s.AccessToken("wx_stable"){
+		test.Error("stable token should rotate in window")
+	}
+	if errcode := get(test,s.URL()+"/cgi-bin/menu/get?access_token="+forced).Get("errcode").Int();errcode != 0{
+		test.Error("rotated token should be valid before expiry",errcode)
+	}
+}
@@ -5,7 +5,11 @@ go 1.12
 require (
 	github.com/BurntSushi/toml v0.3.1
 	github.com/dbldqt/util v0.0.0-20190722064201-8ac74f65516d
+	github.com/golang/protobuf v1.4.1
 	github.com/stretchr/testify v1.3.0 // indirect
 	github.com/tidwall/gjson v1.3.2
 	github.com/valyala/fasthttp v1.4.0
+	go.etcd.io/bbolt v1.3.5
+	google.golang.org/grpc v1.33.2
+	google.golang.org/protobuf v1.25.0
 )
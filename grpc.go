@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"log"
+	"net"
+	"strconv"
+	"github.com/dbldqt/wechatTokenServer/config"
+	"github.com/dbldqt/wechatTokenServer/pb"
+	"github.com/dbldqt/wechatTokenServer/wechat"
+)
+
+const (
+	GRPC_ADMIN_TOKEN_METADATA = "x-admin-token"
+)
+
+//grpc接口，与http接口共用WechatMan、ip白名单和AdminToken
+type tokenServer struct {
+	pb.UnimplementedTokenServiceServer
+	wechatman *wechat.WechatMan
+}
+
+//在单独的端口上启动grpc服务
+func serveGrpc(port int,wechatman *wechat.WechatMan) error{
+	listener,err := net.Listen("tcp",":"+strconv.Itoa(port))
+	if err != nil{
+		return err
+	}
+	server := grpc.NewServer()
+	pb.RegisterTokenServiceServer(server,&tokenServer{wechatman:wechatman})
+	go func(){
+		if err := server.Serve(listener);err != nil{
+			log.Println("grpc serve error "+err.Error())
+		}
+	}()
+	return nil
+}
+
+//把wechat包返回的错误转换为grpc状态码
+func grpcError(err error) error{
+	switch err{
+	case wechat.ErrAppNotFound,wechat.ErrComponentNotFound:
+		return status.Error(codes.NotFound,err.Error())
+	case wechat.ErrTokenNotReady,wechat.ErrTicketNotReady:
+		return status.Error(codes.Unavailable,err.Error())
+	}
+	return status.Error(codes.Internal,err.Error())
+}
+
+func grpcPeerIP(ctx context.Context) string{
+	p,ok := peer.FromContext(ctx)
+	if !ok{
+		return ""
+	}
+	host,_,err := net.SplitHostPort(p.Addr.String())
+	if err != nil{
+		return p.Addr.String()
+	}
+	return host
+}
+
+func grpcQueryAuth(ctx context.Context) error{
+	if !QueryIpAuth(grpcPeerIP(ctx)){
+		return status.Error(codes.PermissionDenied,"ip not in white list")
+	}
+	return nil
+}
+
+func grpcAdminAuth(ctx context.Context) error{
+	if !ReloadIpAuth(grpcPeerIP(ctx)){
+		return status.Error(codes.PermissionDenied,"ip not in admin white list")
+	}
+	var token string
+	if md,ok := metadata.FromIncomingContext(ctx);ok && len(md.Get(GRPC_ADMIN_TOKEN_METADATA)) > 0{
+		token = md.Get(GRPC_ADMIN_TOKEN_METADATA)[0]
+	}
+	adminToken := config.GetConfigMan().GetConfig().GetAdminToken()
+	if adminToken == "" || subtle.ConstantTimeCompare([]byte(token),[]byte(adminToken)) != 1{
+		return status.Error(codes.Unauthenticated,"token error")
+	}
+	return nil
+}
+
+func (ts *tokenServer) GetToken(ctx context.Context,req *pb.GetTokenRequest) (*pb.TokenResult,error){
+	if err := grpcQueryAuth(ctx);err != nil{
+		return nil,err
+	}
+	accessToken,expireAt,err := ts.wechatman.QueryAccessToken(req.Appid,req.Token)
+	result := &pb.TokenResult{Appid:req.Appid,AccessToken:accessToken,ExpireAt:expireAt,Msg:"success"}
+	if err == wechat.ErrAppDeleted{
+		result.Errcode = ERRCODE_APP_DELETED
+		result.Msg = err.Error()
+	}else if err != nil{
+		return nil,grpcError(err)
+	}
+	return result,nil
+}
+
+func (ts *tokenServer) BatchGetToken(ctx context.Context,req *pb.BatchGetTokenRequest) (*pb.BatchGetTokenResponse,error){
+	if err := grpcQueryAuth(ctx);err != nil{
+		return nil,err
+	}
+	if len(req.Apps) == 0 || len(req.Apps) > MAX_BATCH_SIZE{
+		return nil,status.Error(codes.InvalidArgument,"need 1 to "+strconv.Itoa(MAX_BATCH_SIZE)+" appids")
+	}
+	queries := make([]*wechat.TokenQuery,0,len(req.Apps))
+	for _,app := range req.Apps{
+		queries = append(queries,&wechat.TokenQuery{AppID:app.Appid,Token:app.Token})
+	}
+	resp := &pb.BatchGetTokenResponse{}
+	for _,item := range ts.wechatman.BatchQueryAccessToken(queries...){
+		result := &pb.TokenResult{Appid:item.AppID,AccessToken:item.AccessToken,ExpireAt:item.ExpireAt,Msg:"success"}
+		if item.Err != nil{
+			_,errcode := wechatErrorStatus(item.Err)
+			result.Errcode = int32(errcode)
+			result.Msg = item.Err.Error()
+		}
+		resp.Tokens = append(resp.Tokens,result)
+	}
+	return resp,nil
+}
+
+func (ts *tokenServer) ForceRefresh(ctx context.Context,req *pb.ForceRefreshRequest) (*pb.ForceRefreshResponse,error){
+	if err := grpcQueryAuth(ctx);err != nil{
+		return nil,err
+	}
+	_,_,err := ts.wechatman.QueryAccessToken(req.Appid,req.Token)
+	if err != nil && err != wechat.ErrTokenNotReady{
+		return nil,grpcError(err)
+	}
+	if !ts.wechatman.IsLeader(){
+		return nil,status.Error(codes.Unavailable,"not leader")
+	}
+	ts.wechatman.ForceRefreshAccessToken(req.Appid)
+	return &pb.ForceRefreshResponse{},nil
+}
+
+//校验所有应用的token后订阅，last_event_id不为0时先补发历史事件，错过太多时先推送当前accessToken
+func (ts *tokenServer) WatchTokens(req *pb.WatchTokensRequest,stream pb.TokenService_WatchTokensServer) error{
+	if err := grpcQueryAuth(stream.Context());err != nil{
+		return err
+	}
+	if len(req.Apps) == 0{
+		return status.Error(codes.InvalidArgument,"need one app at least")
+	}
+	keys := make([]string,0,len(req.Apps))
+	currents := make([]*wechat.Event,0,len(req.Apps))
+	for _,app := range req.Apps{
+		current,err := ts.wechatman.CurrentTokenEvent(app.Appid,app.Token)
+		if err != nil{
+			return grpcError(err)
+		}
+		keys = append(keys,current.Key())
+		currents = append(currents,current)
+	}
+	sub,backlog,complete := wechat.GetEventBus().SubscribeSince(req.LastEventId,keys...)
+	defer sub.Close()
+	if !complete{
+		for _,current := range currents{
+			if current.AccessToken == ""{
+				continue
+			}
+			if err := stream.Send(pbEvent(current));err != nil{
+				return err
+			}
+		}
+	}
+	for _,event := range backlog{
+		if err := stream.Send(pbEvent(event));err != nil{
+			return err
+		}
+	}
+	for{
+		select{
+		case <-stream.Context().Done():
+			return nil
+		case event,ok := <-sub.C:
+			if !ok{
+				return status.Error(codes.Unavailable,"subscriber too slow, resubscribe with last_event_id")
+			}
+			if err := stream.Send(pbEvent(event));err != nil{
+				return err
+			}
+		}
+	}
+}
+
+func pbEvent(event *wechat.Event) *pb.TokenEvent{
+	return &pb.TokenEvent{
+		Id:event.ID,
+		Type:event.Type,
+		Appid:event.AppID,
+		ComponentAppid:event.ComponentAppID,
+		AccessToken:event.AccessToken,
+		UpdateTime:event.UpdateTime,
+		ExpireAt:event.ExpireAt,
+		Errcode:int32(event.Errcode),
+		Error:event.Error,
+		Source:event.Source,
+		Time:event.Time,
+	}
+}
+
+func (ts *tokenServer) ListApps(ctx context.Context,req *pb.ListAppsRequest) (*pb.ListAppsResponse,error){
+	if err := grpcAdminAuth(ctx);err != nil{
+		return nil,err
+	}
+	resp := &pb.ListAppsResponse{}
+	for _,app := range ts.wechatman.ListAppStatus(){
+		resp.Apps = append(resp.Apps,&pb.AppStatus{
+			Appid:app.AppID,
+			ComponentAppid:app.ComponentAppID,
+			Type:app.Type,
+			Provider:app.Provider,
+			StableToken:app.StableToken,
+			HasToken:app.HasToken,
+			UpdateTime:app.UpdateTime,
+			ExpireAt:app.ExpireAt,
+			Deleted:app.Deleted,
+			Tickets:app.Tickets,
+		})
+	}
+	return resp,nil
+}
+
+func (ts *tokenServer) Reload(ctx context.Context,req *pb.ReloadRequest) (*pb.ReloadResponse,error){
+	if err := grpcAdminAuth(ctx);err != nil{
+		return nil,err
+	}
+	if err := reloadConfig(ts.wechatman);err != nil{
+		return nil,status.Error(codes.Internal,err.Error())
+	}
+	return &pb.ReloadResponse{},nil
+}
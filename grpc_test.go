@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"github.com/dbldqt/wechatTokenServer/config"
+	"github.com/dbldqt/wechatTokenServer/pb"
+	"github.com/dbldqt/wechatTokenServer/store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGrpcTokenService(test *testing.T){
+	config.GetConfigMan().SetConfig(&config.Config{AdminToken:"admin",AdminIpList:[]string{"127.0.0.1"}})
+	wechatman := testWechatMan(test)
+	listener := bufconn.Listen(1024*1024)
+	server := grpc.NewServer()
+	pb.RegisterTokenServiceServer(server,&tokenServer{wechatman:wechatman})
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn,err := grpc.Dial("bufnet",grpc.WithInsecure(),grpc.WithContextDialer(func(ctx context.Context,addr string) (net.Conn,error){
+		return listener.Dial()
+	}))
+	if err != nil{
+		test.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewTokenServiceClient(conn)
+	ctx := context.Background()
+
+	if _,err := client.GetToken(ctx,&pb.GetTokenRequest{Appid:"wx_test",Token:"wrong"});status.Code(err) != codes.NotFound{
+		test.Error("wrong token should not found",err)
+	}
+	if _,err := client.GetToken(ctx,&pb.GetTokenRequest{Appid:"wx_test",Token:"token"});status.Code(err) != codes.Unavailable{
+		test.Error("token not ready should unavailable",err)
+	}
+	resp,err := client.BatchGetToken(ctx,&pb.BatchGetTokenRequest{Apps:[]*pb.AppCredential{
+		{Appid:"wx_test",Token:"token"},
+		{Appid:"wx_none",Token:"token"},
+	}})
+	if err != nil || len(resp.Tokens) != 2 || resp.Tokens[0].Errcode != ERRCODE_UNAVAILABLE || resp.Tokens[1].Errcode != ERRCODE_NOT_FOUND{
+		test.Error("batch get token error",resp,err)
+	}
+	//bufconn的地址不是ip，管理接口拒绝
+	if _,err := client.ListApps(ctx,&pb.ListAppsRequest{});status.Code(err) != codes.PermissionDenied{
+		test.Error("list apps should check admin ip",err)
+	}
+
+	//last_event_id已经无法补发时先推送当前accessToken
+	tokens := store.NewMemoryStore()
+	tokens.Put(&store.Token{AppID:"wx_test",AccessToken:"current_token",UpdateTime:time.Now().Unix(),ExpireAt:time.Now().Add(time.Hour).Unix()})
+	wechatman.SetTokenStore(tokens)
+	if err := wechatman.LoadTokenStore();err != nil{
+		test.Fatal(err)
+	}
+	watchCtx,cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream,err := client.WatchTokens(watchCtx,&pb.WatchTokensRequest{Apps:[]*pb.AppCredential{{Appid:"wx_test",Token:"token"}},LastEventId:1<<40})
+	if err != nil{
+		test.Fatal(err)
+	}
+	if event,err := stream.Recv();err != nil || event.Id != 0 || event.AccessToken != "current_token"{
+		test.Error("incomplete backlog should send current token first",event,err)
+	}
+}
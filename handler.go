@@ -0,0 +1,475 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/valyala/fasthttp"
+	"log"
+	"strings"
+	"time"
+	"github.com/dbldqt/wechatTokenServer/cluster"
+	"github.com/dbldqt/wechatTokenServer/config"
+	"github.com/dbldqt/wechatTokenServer/wechat"
+)
+
+//接口返回的errcode，百位以上和http状态码对应
+const (
+	ERRCODE_SUCCESS = 0
+	ERRCODE_BAD_REQUEST = 40000
+	ERRCODE_UNAUTHORIZED = 40100
+	ERRCODE_FORBIDDEN = 40300
+	ERRCODE_NOT_FOUND = 40400
+	ERRCODE_METHOD_NOT_ALLOWED = 40500
+	ERRCODE_APP_DELETED = 41000
+	ERRCODE_INTERNAL = 50000
+	ERRCODE_BAD_GATEWAY = 50200
+	ERRCODE_UNAVAILABLE = 50300
+)
+
+type ErrorResult struct{
+	Errcode    int    `json:"errcode"`
+	Msg        string `json:"msg"`
+	ServerTime int64  `json:"serverTime"`
+}
+
+type Result struct{
+	Errcode int        `json:"errcode"`
+	AccessToken string `json:"accessToken"`
+	Msg string         `json:"msg"`
+	ServerTime int64   `json:"serverTime"`
+	ExpireAt   int64   `json:"expireAt"`
+}
+
+type TicketResult struct{
+	Errcode    int    `json:"errcode"`
+	Ticket     string `json:"ticket"`
+	Msg        string `json:"msg"`
+	ServerTime int64  `json:"serverTime"`
+	ExpireAt   int64  `json:"expireAt"`
+}
+
+type JsapiConfigResult struct{
+	wechat.JsapiConfig
+	Errcode    int    `json:"errcode"`
+	Msg        string `json:"msg"`
+	ServerTime int64  `json:"serverTime"`
+}
+
+type CardExtResult struct{
+	Errcode    int            `json:"errcode"`
+	CardExt    wechat.CardExt `json:"cardExt"`
+	Msg        string         `json:"msg"`
+	ServerTime int64          `json:"serverTime"`
+}
+
+func requesthandler(ctx *fasthttp.RequestCtx){
+	log.Println(ctx.RemoteIP().String()+" request "+ctx.URI().String())
+	//第三方平台授权事件由微信服务器post推送
+	if string(ctx.Path()) == "/component/notify"{
+		componentNotifyHandler(ctx)
+		return
+	}
+	if strings.HasPrefix(string(ctx.Path()),V1_PREFIX){
+		v1Handler(ctx)
+		return
+	}
+	if strings.HasPrefix(string(ctx.Path()),PROXY_PREFIX){
+		proxyHandler(ctx)
+		return
+	}
+	if !ctx.IsGet(){
+		replyError(ctx,fasthttp.StatusMethodNotAllowed,ERRCODE_METHOD_NOT_ALLOWED,"only get supported")
+		return
+	}
+	switch(string(ctx.Path())){
+	case cluster.PEER_STATE_PATH:
+		peerStateHandler(ctx)
+	case "/query":
+		queryHandler(ctx)
+	case "/update":
+		updateHandler(ctx)
+	case "/ticket","/cardticket":
+		ticketHandler(ctx)
+	case "/jssdk":
+		jssdkHandler(ctx)
+	case "/cardsign":
+		cardSignHandler(ctx)
+	case "/reload":
+		reloadHandler(ctx)
+	default:
+		replyError(ctx,fasthttp.StatusNotFound,ERRCODE_NOT_FOUND,"no this route")
+	}
+}
+
+func peerStateHandler(ctx *fasthttp.RequestCtx){
+	if !PeerAuth(string(ctx.Request.Header.Peek(cluster.PEER_TOKEN_HEADER))){
+		replyError(ctx,fasthttp.StatusUnauthorized,ERRCODE_UNAUTHORIZED,"token error")
+		return
+	}
+	//只有leader提供同步数据，follower之间不互相同步
+	if elector == nil || !elector.IsLeader(){
+		replyError(ctx,fasthttp.StatusServiceUnavailable,ERRCODE_UNAVAILABLE,"not leader")
+		return
+	}
+	wechatman,ok := getWechatMan(ctx)
+	if !ok{
+		return
+	}
+	state,err := wechatman.ExportPeerState()
+	if err != nil{
+		replyError(ctx,fasthttp.StatusInternalServerError,ERRCODE_INTERNAL,err.Error())
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.Response.SetBody(state)
+}
+
+func queryHandler(ctx *fasthttp.RequestCtx){
+	if !requireArgs(ctx,"appid","token") || !queryIpAuth(ctx){
+		return
+	}
+	appid := string(ctx.QueryArgs().Peek("appid"))
+	deprecated(ctx,"/v1/apps/"+appid+"/token")
+	replyAccessToken(ctx,appid,string(ctx.QueryArgs().Peek("token")))
+}
+
+func replyAccessToken(ctx *fasthttp.RequestCtx,appid,token string){
+	wechatman,ok := getWechatMan(ctx)
+	if !ok{
+		return
+	}
+	result := Result{
+		ServerTime:time.Now().Unix(),
+	}
+	accessToken,expireAt,err := wechatman.QueryAccessToken(appid,token)
+	if err != nil{
+		log.Println(appid+" query accesstoken error "+err.Error())
+		result.Errcode = setWechatError(ctx,err)
+		result.Msg = err.Error()
+	}else{
+		log.Println(appid+" query accesstoken success")
+		result.Msg = "success"
+	}
+	result.ExpireAt = expireAt
+	result.AccessToken = accessToken
+	replyJson(ctx,result)
+}
+
+func updateHandler(ctx *fasthttp.RequestCtx){
+	if !requireArgs(ctx,"appid","token") || !queryIpAuth(ctx){
+		return
+	}
+	appid := string(ctx.QueryArgs().Peek("appid"))
+	deprecated(ctx,"/v1/apps/"+appid+"/token/refresh")
+	replyForceRefresh(ctx,appid,string(ctx.QueryArgs().Peek("token")))
+}
+
+func replyForceRefresh(ctx *fasthttp.RequestCtx,appid,token string){
+	wechatman,ok := getWechatMan(ctx)
+	if !ok{
+		return
+	}
+	_,_,err := wechatman.QueryAccessToken(appid,token)
+	if err != nil && err != wechat.ErrTokenNotReady{
+		log.Println(appid+" update accesstoken error "+err.Error())
+		replyError(ctx,0,setWechatError(ctx,err),err.Error())
+		return
+	}
+	//follower不请求微信接口，由leader刷新
+	if !wechatman.IsLeader(){
+		replyError(ctx,fasthttp.StatusServiceUnavailable,ERRCODE_UNAVAILABLE,"not leader")
+		return
+	}
+	log.Println(appid+" update success")
+	wechatman.ForceRefreshAccessToken(appid)
+	replyError(ctx,fasthttp.StatusOK,ERRCODE_SUCCESS,"success")
+}
+
+func ticketHandler(ctx *fasthttp.RequestCtx){
+	if !requireArgs(ctx,"appid","token") || !queryIpAuth(ctx){
+		return
+	}
+	appid := string(ctx.QueryArgs().Peek("appid"))
+	token := string(ctx.QueryArgs().Peek("token"))
+
+	wechatman,ok := getWechatMan(ctx)
+	if !ok{
+		return
+	}
+	ticketType := wechat.TICKET_TYPE_JSAPI
+	if string(ctx.Path()) == "/cardticket"{
+		ticketType = wechat.TICKET_TYPE_WX_CARD
+	}
+	result := TicketResult{
+		ServerTime:time.Now().Unix(),
+	}
+	ticket,expireAt,err := wechatman.QueryTicket(appid,token,ticketType)
+	if err != nil{
+		log.Println(appid+" query "+ticketType+" ticket error "+err.Error())
+		result.Errcode = setWechatError(ctx,err)
+		result.Msg = err.Error()
+	}else{
+		log.Println(appid+" query "+ticketType+" ticket success")
+		result.Msg = "success"
+	}
+	result.ExpireAt = expireAt
+	result.Ticket = ticket
+	replyJson(ctx,result)
+}
+
+func jssdkHandler(ctx *fasthttp.RequestCtx){
+	if !requireArgs(ctx,"appid","token","url") || !queryIpAuth(ctx){
+		return
+	}
+	appid := string(ctx.QueryArgs().Peek("appid"))
+	token := string(ctx.QueryArgs().Peek("token"))
+	url := string(ctx.QueryArgs().Peek("url"))
+
+	wechatman,ok := getWechatMan(ctx)
+	if !ok{
+		return
+	}
+	result := JsapiConfigResult{
+		ServerTime:time.Now().Unix(),
+	}
+	jsapiConfig,err := wechatman.BuildJsapiConfig(appid,token,url)
+	if err != nil{
+		log.Println(appid+" build jssdk config error "+err.Error())
+		result.Errcode = setWechatError(ctx,err)
+		result.Msg = err.Error()
+	}else{
+		log.Println(appid+" build jssdk config success")
+		result.Msg = "success"
+		result.JsapiConfig = *jsapiConfig
+	}
+	replyJson(ctx,result)
+}
+
+func cardSignHandler(ctx *fasthttp.RequestCtx){
+	if !requireArgs(ctx,"appid","token","card_id") || !queryIpAuth(ctx){
+		return
+	}
+	appid := string(ctx.QueryArgs().Peek("appid"))
+	token := string(ctx.QueryArgs().Peek("token"))
+	cardID := string(ctx.QueryArgs().Peek("card_id"))
+	code := string(ctx.QueryArgs().Peek("code"))
+	openid := string(ctx.QueryArgs().Peek("openid"))
+
+	wechatman,ok := getWechatMan(ctx)
+	if !ok{
+		return
+	}
+	result := CardExtResult{
+		ServerTime:time.Now().Unix(),
+	}
+	cardExt,err := wechatman.BuildCardExt(appid,token,cardID,code,openid)
+	if err != nil{
+		log.Println(appid+" build card ext error "+err.Error())
+		result.Errcode = setWechatError(ctx,err)
+		result.Msg = err.Error()
+	}else{
+		log.Println(appid+" build card ext success")
+		result.Msg = "success"
+		result.CardExt = *cardExt
+	}
+	replyJson(ctx,result)
+}
+
+func reloadHandler(ctx *fasthttp.RequestCtx){
+	if !requireArgs(ctx,"token") || !adminAuth(ctx,string(ctx.QueryArgs().Peek("token"))){
+		return
+	}
+	deprecated(ctx,"/v1/admin/reload")
+	replyReload(ctx)
+}
+
+func replyReload(ctx *fasthttp.RequestCtx){
+	wechatMan,ok := getWechatMan(ctx)
+	if !ok{
+		return
+	}
+	if err := reloadConfig(wechatMan);err != nil{
+		replyError(ctx,fasthttp.StatusInternalServerError,ERRCODE_INTERNAL,err.Error())
+		return
+	}
+	replyError(ctx,fasthttp.StatusOK,ERRCODE_SUCCESS,"config is reloading")
+}
+
+//重新读取配置文件，配置文件正确时在后台重建WechatMan
+func reloadConfig(wechatMan *wechat.WechatMan) error{
+	conf,err := config.LoadConfig(configFile)
+	if err != nil{
+		return err
+	}
+	config.GetConfigMan().SetConfig(conf)
+
+	go func (){
+		wechatMan.SetSnapshotFile(conf.GetSnapshotFile())
+		wechatMan.SetRetryPolicy(conf.GetRetryPolicy())
+		err := wechatMan.Rebuild(conf.GetAheadTime(),conf.GetLoopTime(),conf.GetWechatConfigs()...)
+		if err != nil{
+			log.Println("rebuild error "+err.Error())
+			return
+		}
+		log.Println("reload success")
+	}()
+	return nil
+}
+
+//接收微信推送的component_verify_ticket等授权事件，处理成功后需要返回success
+func componentNotifyHandler(ctx *fasthttp.RequestCtx){
+	if !ctx.IsPost(){
+		replyError(ctx,fasthttp.StatusMethodNotAllowed,ERRCODE_METHOD_NOT_ALLOWED,"only post supported")
+		return
+	}
+	wechatman,ok := getWechatMan(ctx)
+	if !ok{
+		return
+	}
+	err := wechatman.HandleComponentNotify(
+		string(ctx.QueryArgs().Peek("timestamp")),
+		string(ctx.QueryArgs().Peek("nonce")),
+		string(ctx.QueryArgs().Peek("msg_signature")),
+		ctx.PostBody(),
+	)
+	if err != nil{
+		log.Println("handle component notify error "+err.Error())
+		replyError(ctx,0,setWechatError(ctx,err),err.Error())
+		return
+	}
+	//微信要求原样返回success，不能使用json
+	ctx.Response.SetBody([]byte("success"))
+}
+
+func replyJson(ctx *fasthttp.RequestCtx,result interface{}){
+	res,err := json.Marshal(result)
+	if err != nil{
+		log.Println("marshal error "+err.Error())
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		res = []byte(`{"errcode":50000,"msg":"marshal error"}`)
+	}
+	ctx.SetContentType("application/json")
+	ctx.Response.SetBody(res)
+}
+
+//status为0时保留已经设置的状态码
+func replyError(ctx *fasthttp.RequestCtx,status,errcode int,msg string){
+	if status != 0{
+		ctx.SetStatusCode(status)
+	}
+	replyJson(ctx,ErrorResult{
+		Errcode:errcode,
+		Msg:msg,
+		ServerTime:time.Now().Unix(),
+	})
+}
+
+func requireArgs(ctx *fasthttp.RequestCtx,names ...string) bool{
+	for _,name := range names{
+		if !ctx.QueryArgs().Has(name){
+			replyError(ctx,fasthttp.StatusBadRequest,ERRCODE_BAD_REQUEST,"param not enough, need "+name)
+			return false
+		}
+	}
+	return true
+}
+
+//管理接口需要同时校验ip白名单和AdminToken
+func adminAuth(ctx *fasthttp.RequestCtx,token string) bool{
+	if !ReloadIpAuth(ctx.RemoteIP().String()){
+		replyError(ctx,fasthttp.StatusForbidden,ERRCODE_FORBIDDEN,"ip not in admin white list")
+		return false
+	}
+	adminToken := config.GetConfigMan().GetConfig().GetAdminToken()
+	if adminToken == "" || subtle.ConstantTimeCompare([]byte(token),[]byte(adminToken)) != 1{
+		replyError(ctx,fasthttp.StatusUnauthorized,ERRCODE_UNAUTHORIZED,"token error")
+		return false
+	}
+	return true
+}
+
+//旧接口继续可用，响应头提示调用方迁移到v1接口
+func deprecated(ctx *fasthttp.RequestCtx,successor string){
+	ctx.Response.Header.Set("Deprecation","true")
+	ctx.Response.Header.Set("Link","<"+successor+">; rel=\"successor-version\"")
+}
+
+func queryIpAuth(ctx *fasthttp.RequestCtx) bool{
+	if !QueryIpAuth(ctx.RemoteIP().String()){
+		replyError(ctx,fasthttp.StatusForbidden,ERRCODE_FORBIDDEN,"ip not in white list")
+		return false
+	}
+	return true
+}
+
+func getWechatMan(ctx *fasthttp.RequestCtx) (*wechat.WechatMan,bool){
+	wechatman,err := wechat.GetWechatMan()
+	if err != nil{
+		log.Println("get wechatman error "+err.Error())
+		replyError(ctx,fasthttp.StatusServiceUnavailable,ERRCODE_UNAVAILABLE,err.Error())
+		return nil,false
+	}
+	return wechatman,true
+}
+
+//根据wechat包返回的错误设置http状态码，返回对应的errcode
+func setWechatError(ctx *fasthttp.RequestCtx,err error) int{
+	status,errcode := wechatErrorStatus(err)
+	ctx.SetStatusCode(status)
+	return errcode
+}
+
+func wechatErrorStatus(err error) (int,int){
+	status,errcode := fasthttp.StatusInternalServerError,ERRCODE_INTERNAL
+	switch err{
+	case wechat.ErrAppNotFound,wechat.ErrComponentNotFound:
+		status,errcode = fasthttp.StatusNotFound,ERRCODE_NOT_FOUND
+	case wechat.ErrAppDeleted:
+		//已删除的应用仍然返回最后的accessToken，由调用方决定是否使用
+		status,errcode = fasthttp.StatusOK,ERRCODE_APP_DELETED
+	case wechat.ErrTokenNotReady,wechat.ErrTicketNotReady:
+		status,errcode = fasthttp.StatusServiceUnavailable,ERRCODE_UNAVAILABLE
+	case wechat.ErrMsgSignature:
+		status,errcode = fasthttp.StatusUnauthorized,ERRCODE_UNAUTHORIZED
+	}
+	return status,errcode
+}
+
+func QueryIpAuth(ip string) bool{
+	conf := config.GetConfigMan().GetConfig()
+	conf.RLock()
+	if !conf.UseIpWhiteList{
+		conf.RUnlock()
+		return true
+	}
+	iplist := conf.GetIpList()
+	for _,ipSet := range iplist{
+		if ipSet == ip {
+			conf.RUnlock()
+			return true
+		}
+	}
+	conf.RUnlock()
+	log.Println(ip+"not in ip list")
+	return false
+}
+
+func PeerAuth(token string) bool{
+	peerToken := config.GetConfigMan().GetConfig().GetLeaderConfig().PeerToken
+	return peerToken != "" && subtle.ConstantTimeCompare([]byte(token),[]byte(peerToken)) == 1
+}
+
+func ReloadIpAuth(ip string) bool{
+	conf := config.GetConfigMan().GetConfig()
+	conf.RLock()
+	iplist := conf.GetAdminIpList()
+	for _,ipSet := range iplist{
+		if ipSet == ip {
+			conf.RUnlock()
+			return true
+		}
+	}
+	conf.RUnlock()
+	log.Println(ip+"not in admin ip list")
+	return false
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/dbldqt/wechatTokenServer/client"
+	"github.com/dbldqt/wechatTokenServer/wechat"
+	"github.com/tidwall/gjson"
+	"github.com/valyala/fasthttp"
+	"testing"
+)
+
+//WechatMan是单例，所有测试共用同一个配置
+func testWechatMan(test *testing.T) *wechat.WechatMan{
+	wechatman,err := wechat.BuildWechatMan(600,10,&wechat.WechatConfig{AppID:"wx_test",AppSecret:"secret",Token:"token"})
+	if err != nil{
+		test.Fatal(err)
+	}
+	return wechatman
+}
+
+func doRequest(method,uri string) *fasthttp.RequestCtx{
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(uri)
+	requesthandler(ctx)
+	return ctx
+}
+
+func TestRequestHandlerError(test *testing.T){
+	cases := []struct{
+		method  string
+		uri     string
+		status  int
+		errcode int64
+	}{
+		{"GET","/nothing",fasthttp.StatusNotFound,ERRCODE_NOT_FOUND},
+		{"POST","/query?appid=wx&token=t",fasthttp.StatusMethodNotAllowed,ERRCODE_METHOD_NOT_ALLOWED},
+		{"GET","/query?appid=wx",fasthttp.StatusBadRequest,ERRCODE_BAD_REQUEST},
+		{"GET","/component/notify",fasthttp.StatusMethodNotAllowed,ERRCODE_METHOD_NOT_ALLOWED},
+		{"GET","/v1/apps/wx/nothing",fasthttp.StatusNotFound,ERRCODE_NOT_FOUND},
+		{"GET","/v1/apps/wx/token/refresh",fasthttp.StatusMethodNotAllowed,ERRCODE_METHOD_NOT_ALLOWED},
+		{"GET","/v1/apps/wx/token",fasthttp.StatusUnauthorized,ERRCODE_UNAUTHORIZED},
+		{"GET","/v1/admin/reload",fasthttp.StatusMethodNotAllowed,ERRCODE_METHOD_NOT_ALLOWED},
+		{"GET","/v1/tokens/batch",fasthttp.StatusMethodNotAllowed,ERRCODE_METHOD_NOT_ALLOWED},
+		{"GET","/proxy/wx",fasthttp.StatusNotFound,ERRCODE_NOT_FOUND},
+		{"PUT","/proxy/wx/cgi-bin/menu/get",fasthttp.StatusMethodNotAllowed,ERRCODE_METHOD_NOT_ALLOWED},
+		{"GET","/proxy/wx/cgi-bin/menu/get",fasthttp.StatusUnauthorized,ERRCODE_UNAUTHORIZED},
+	}
+	for _,c := range cases{
+		ctx := doRequest(c.method,c.uri)
+		if ctx.Response.StatusCode() != c.status{
+			test.Errorf("%s %s status %d,want %d",c.method,c.uri,ctx.Response.StatusCode(),c.status)
+		}
+		result := gjson.ParseBytes(ctx.Response.Body())
+		if result.Get("errcode").Int() != c.errcode || result.Get("msg").String() == ""{
+			test.Errorf("%s %s body %s",c.method,c.uri,ctx.Response.Body())
+		}
+	}
+}
+
+//client包解析的Result需要与接口返回的一致
+func TestClientResultShape(test *testing.T){
+	data,_ := json.Marshal(WaitResult{Result:Result{Errcode:ERRCODE_APP_DELETED,AccessToken:"token",Msg:"msg",ServerTime:1,ExpireAt:2},UpdateTime:3})
+	result := client.Result{}
+	if err := json.Unmarshal(data,&result);err != nil{
+		test.Fatal(err)
+	}
+	if result != (client.Result{Errcode:ERRCODE_APP_DELETED,AccessToken:"token",Msg:"msg",ServerTime:1,ExpireAt:2,UpdateTime:3}){
+		test.Error("client result shape error",result)
+	}
+}
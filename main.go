@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/valyala/fasthttp"
@@ -9,21 +8,44 @@ import (
 	"os"
 	"strconv"
 	"time"
+	"github.com/dbldqt/wechatTokenServer/cluster"
 	"github.com/dbldqt/wechatTokenServer/config"
+	"github.com/dbldqt/wechatTokenServer/resp"
+	"github.com/dbldqt/wechatTokenServer/secure"
+	"github.com/dbldqt/wechatTokenServer/store"
 	"github.com/dbldqt/wechatTokenServer/wechat"
 )
 var configFile string
 var test bool
+var keyFile string
+var encryptValue string
+var elector *cluster.Elector
 
 func init(){
 	flag.StringVar(&configFile,"conf","./config.toml","assign the config file path")
 	flag.BoolVar(&test,"test",false,"is test config file")
+	flag.StringVar(&keyFile,"keyfile","","master key file used to encrypt secrets and local state, env "+secure.MASTER_KEY_ENV+" takes precedence")
+	flag.StringVar(&encryptValue,"encrypt","","encrypt the value with master key for config file and exit")
 
 	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
 }
 
 func main(){
 	flag.Parse()
+	err := secure.LoadMasterKey(keyFile)
+	if err != nil{
+		log.Panicln("load master key error:"+err.Error())
+	}
+	//加密配置项
+	if encryptValue != ""{
+		encrypted,err := secure.EncryptString(encryptValue)
+		if err != nil{
+			log.Panicln("encrypt error:"+err.Error())
+		}
+		fmt.Println(encrypted)
+		return
+	}
+
 	conf,err := config.LoadConfig(configFile)
 	if err != nil{
 		log.Panicln("config file error:"+err.Error())
@@ -47,179 +69,90 @@ func main(){
 	if err != nil{
 		log.Panicln("get wehcatman error "+err.Error())
 	}
-	err = wechatman.Run()
+	tokenStore,err := store.NewTokenStore(conf.GetTokenStore())
 	if err != nil{
-		log.Panicln("wechatman run error "+err.Error())
+		log.Panicln("token store error "+err.Error())
 	}
-
-	err = fasthttp.ListenAndServe(":"+strconv.Itoa(conf.GetPort()),requesthandler)
+	//配置了redis时，accessToken更新同步写入redis
+	if redisAddr,redisPassword,redisDB,redisKeyTemplate := conf.GetRedis();redisAddr != ""{
+		redisStore,err := store.NewRedisStore(redisAddr,redisPassword,redisDB,redisKeyTemplate)
+		if err != nil{
+			log.Panicln("redis store error "+err.Error())
+		}
+		tokenStore = store.NewMirrorStore(tokenStore,redisStore)
+	}
+	wechatman.SetTokenStore(tokenStore)
+	wechatman.SetRetryPolicy(conf.GetRetryPolicy())
+	err = wechatman.LoadTokenStore()
 	if err != nil{
-		log.Panicln("fasthttp error "+err.Error())
-		return
+		log.Println("load token store error "+err.Error())
 	}
-}
-
-type Result struct{
-	AccessToken string `json:"accessToken"`
-	Msg string         `json:"msg"`
-	ServerTime int64   `json:"serverTime"`
-	ExpireAt   int64   `json:"expireAt"`
-}
-
-func requesthandler(ctx *fasthttp.RequestCtx){
-	log.Println(ctx.RemoteIP().String()+" request "+ctx.URI().String())
-	if !ctx.IsGet(){
-		ctx.Response.SetBody([]byte("only get supported"))
-		return
+	wechatman.SetSnapshotFile(conf.GetSnapshotFile())
+	err = wechatman.LoadSnapshot()
+	if err != nil{
+		log.Println("load snapshot error "+err.Error())
 	}
-	switch(string(ctx.Path())){
-		case "/query":
-			if !ctx.QueryArgs().Has("appid") || !ctx.QueryArgs().Has("token"){
-				ctx.Response.SetBody([]byte("param not enough"))
-				return
-			}
-
-			if !QueryIpAuth(ctx.RemoteIP().String()){
-				ctx.Response.SetBody([]byte("ip not in white list"))
-				return
-			}
-
-			appid := ctx.QueryArgs().Peek("appid")
-			token := ctx.QueryArgs().Peek("token")
-
-			result := Result{
-				ServerTime:time.Now().Unix(),
-			}
-
-			wechatman,err := wechat.GetWechatMan()
-			if err != nil{
-				log.Panicln("get wechatman error "+err.Error())
-			}
-			accessToken,expireAt,err := wechatman.QueryAccessToken(string(appid),string(token))
-			if err != nil{
-				log.Println(string(appid)+"query accesstoken error "+err.Error())
-				result.Msg = err.Error()
-			}else{
-				log.Println(string(appid)+"query accesstoken success")
-				result.Msg = "success"
-			}
-
-			result.ExpireAt = expireAt
-			result.AccessToken = accessToken
-			res,err := json.Marshal(result)
-			if err !=nil{
-				log.Panicln("marshal error"+err.Error())
-				ctx.Response.SetBody([]byte(err.Error()))
-			}else{
-				ctx.Response.SetBody(res)
-			}
-
-			break
-		case "/update":
-			if !ctx.QueryArgs().Has("appid") || !ctx.QueryArgs().Has("token"){
-				ctx.Response.SetBody([]byte("param not enough"))
-				return
-			}
-
-			if !QueryIpAuth(ctx.RemoteIP().String()){
-				ctx.Response.SetBody([]byte("ip not in white list"))
-				return
-			}
-
-			appid := ctx.QueryArgs().Peek("appid")
-			token := ctx.QueryArgs().Peek("token")
-
-			wechatman,err := wechat.GetWechatMan()
-			if err != nil{
-				log.Panicln("get wechatman error "+err.Error())
-				return
-			}
-			_,_,err = wechatman.QueryAccessToken(string(appid),string(token))
+	elector,err = buildElector(conf)
+	if err != nil{
+		log.Panicln("leader election error "+err.Error())
+	}
+	if elector != nil{
+		wechatman.SetLeaderChecker(elector)
+		//配置了peer时follower通过http从leader同步
+		leaderConf := conf.GetLeaderConfig()
+		if len(leaderConf.Peers) > 0{
+			syncer,err := cluster.NewPeerSyncer(elector,wechatman,leaderConf.Peers,leaderConf.PeerToken,
+				time.Duration(leaderConf.SyncInterval)*time.Second,time.Duration(leaderConf.GracePeriod)*time.Second)
 			if err != nil{
-				log.Println(string(appid)+"update accesstoken error "+err.Error())
-				ctx.Response.SetBody([]byte("{\"msg\":\""+err.Error()+"\"}"))
-				return
+				log.Panicln("peer sync error "+err.Error())
 			}
-			log.Println(string(appid)+"update success")
-			wechatman.ForceRefreshAccessToken(string(appid))
-			ctx.Response.SetBody([]byte("{\"msg\":\"success\"}"))
-
-			break
-	case "/reload":
-		if !ctx.QueryArgs().Has("token"){
-			ctx.Response.SetBody([]byte("param not enough"))
-			return
-		}
-
-		if !ReloadIpAuth(ctx.RemoteIP().String()){
-			ctx.Response.SetBody([]byte("ip not in white list"))
-			return
-		}
-		token := string(ctx.QueryArgs().Peek("token"))
-		adminToken := config.GetConfigMan().GetConfig().GetAdminToken()
-		if string(token) != adminToken{
-			ctx.Response.SetBody([]byte("token error"))
-			return
-		}
-
-		conf,err := config.LoadConfig(configFile)
-		if err != nil{
-			ctx.Response.SetBody([]byte(err.Error()))
-			return
-		}
-
-		config.GetConfigMan().SetConfig(conf)
-		wechatMan,err := wechat.GetWechatMan()
-		if err != nil {
-			log.Panicln("get wechatman error "+err.Error())
-			ctx.Response.SetBody([]byte(err.Error()))
-			return
+			wechatman.SetLeaderChecker(syncer)
+			syncer.Run()
 		}
-
-		go func (){
-			err = wechatMan.Rebuild(conf.GetAheadTime(),conf.GetLoopTime(),conf.GetWechatConfigs()...)
-			if err != nil{
-				log.Panicln("rebuild error "+err.Error())
+		elector.Run(func(isLeader bool){
+			//成为leader前先同步一次之前leader的accessToken，避免重复刷新
+			if isLeader{
+				if err := wechatman.LoadTokenStore();err != nil{
+					log.Println("load token store error "+err.Error())
+				}
 			}
-			log.Println("reload success")
-		}()
-		ctx.Response.SetBody([]byte("config is reloading"))
-		break
-	default:
-			ctx.Response.SetBody([]byte("no this route"))
+		})
 	}
-}
-
-func QueryIpAuth(ip string) bool{
-	conf := config.GetConfigMan().GetConfig()
-	conf.RLock()
-	if !conf.UseIpWhiteList{
-		return true
-	}
-	iplist := conf.GetIpList()
-	for _,ipSet := range iplist{
-		if ipSet == ip {
-			conf.RUnlock()
-			return true
+	err = wechatman.Run()
+	if err != nil{
+		log.Panicln("wechatman run error "+err.Error())
+	}
+	if grpcPort := conf.GetGrpcPort();grpcPort > 0{
+		if err := serveGrpc(grpcPort,wechatman);err != nil{
+			log.Panicln("grpc listen error "+err.Error())
 		}
 	}
-	conf.RUnlock()
-	log.Println(ip+"not in ip list")
-	return false
-}
 
-func ReloadIpAuth(ip string) bool{
-	conf := config.GetConfigMan().GetConfig()
-	conf.RLock()
-	iplist := conf.GetAdminIpList()
-	for _,ipSet := range iplist{
-		if ipSet == ip {
-			conf.RUnlock()
-			return true
-		}
+	err = fasthttp.ListenAndServe(":"+strconv.Itoa(conf.GetPort()),requesthandler)
+	if err != nil{
+		log.Panicln("fasthttp error "+err.Error())
+		return
 	}
-	conf.RUnlock()
-	log.Println(ip+"not in admin ip list")
-	return false
 }
 
+//根据配置创建选主，未配置时返回nil，当前节点始终为leader
+func buildElector(conf *config.Config) (*cluster.Elector,error){
+	leaderConf := conf.GetLeaderConfig()
+	var locker cluster.Locker
+	var err error
+	switch leaderConf.Type{
+	case "":
+		return nil,nil
+	case cluster.LOCK_FILE:
+		locker,err = cluster.NewFileLocker(leaderConf.LockFile)
+	case cluster.LOCK_REDIS:
+		redisAddr,redisPassword,redisDB,_ := conf.GetRedis()
+		locker = cluster.NewRedisLocker(resp.NewClient(redisAddr,redisPassword,redisDB),leaderConf.LockKey)
+	case cluster.LOCK_ETCD:
+		locker,err = cluster.NewEtcdLocker(leaderConf.EtcdEndpoint,leaderConf.LockKey)
+	}
+	if err != nil{
+		return nil,err
+	}
+	return cluster.NewElector(leaderConf.NodeID,locker,time.Duration(leaderConf.LockTTL)*time.Second)
+}
@@ -0,0 +1,4 @@
+//grpc接口定义，修改tokenserver.proto后重新生成
+package pb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative tokenserver.proto
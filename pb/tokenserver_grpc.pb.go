@@ -0,0 +1,305 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion7
+
+// TokenServiceClient is the client API for TokenService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type TokenServiceClient interface {
+	GetToken(ctx context.Context, in *GetTokenRequest, opts ...grpc.CallOption) (*TokenResult, error)
+	BatchGetToken(ctx context.Context, in *BatchGetTokenRequest, opts ...grpc.CallOption) (*BatchGetTokenResponse, error)
+	ForceRefresh(ctx context.Context, in *ForceRefreshRequest, opts ...grpc.CallOption) (*ForceRefreshResponse, error)
+	WatchTokens(ctx context.Context, in *WatchTokensRequest, opts ...grpc.CallOption) (TokenService_WatchTokensClient, error)
+	ListApps(ctx context.Context, in *ListAppsRequest, opts ...grpc.CallOption) (*ListAppsResponse, error)
+	Reload(ctx context.Context, in *ReloadRequest, opts ...grpc.CallOption) (*ReloadResponse, error)
+}
+
+type tokenServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTokenServiceClient(cc grpc.ClientConnInterface) TokenServiceClient {
+	return &tokenServiceClient{cc}
+}
+
+func (c *tokenServiceClient) GetToken(ctx context.Context, in *GetTokenRequest, opts ...grpc.CallOption) (*TokenResult, error) {
+	out := new(TokenResult)
+	err := c.cc.Invoke(ctx, "/wechattokenserver.v1.TokenService/GetToken", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenServiceClient) BatchGetToken(ctx context.Context, in *BatchGetTokenRequest, opts ...grpc.CallOption) (*BatchGetTokenResponse, error) {
+	out := new(BatchGetTokenResponse)
+	err := c.cc.Invoke(ctx, "/wechattokenserver.v1.TokenService/BatchGetToken", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenServiceClient) ForceRefresh(ctx context.Context, in *ForceRefreshRequest, opts ...grpc.CallOption) (*ForceRefreshResponse, error) {
+	out := new(ForceRefreshResponse)
+	err := c.cc.Invoke(ctx, "/wechattokenserver.v1.TokenService/ForceRefresh", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenServiceClient) WatchTokens(ctx context.Context, in *WatchTokensRequest, opts ...grpc.CallOption) (TokenService_WatchTokensClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_TokenService_serviceDesc.Streams[0], "/wechattokenserver.v1.TokenService/WatchTokens", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &tokenServiceWatchTokensClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type TokenService_WatchTokensClient interface {
+	Recv() (*TokenEvent, error)
+	grpc.ClientStream
+}
+
+type tokenServiceWatchTokensClient struct {
+	grpc.ClientStream
+}
+
+func (x *tokenServiceWatchTokensClient) Recv() (*TokenEvent, error) {
+	m := new(TokenEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *tokenServiceClient) ListApps(ctx context.Context, in *ListAppsRequest, opts ...grpc.CallOption) (*ListAppsResponse, error) {
+	out := new(ListAppsResponse)
+	err := c.cc.Invoke(ctx, "/wechattokenserver.v1.TokenService/ListApps", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenServiceClient) Reload(ctx context.Context, in *ReloadRequest, opts ...grpc.CallOption) (*ReloadResponse, error) {
+	out := new(ReloadResponse)
+	err := c.cc.Invoke(ctx, "/wechattokenserver.v1.TokenService/Reload", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// TokenServiceServer is the server API for TokenService service.
+// All implementations must embed UnimplementedTokenServiceServer
+// for forward compatibility
+type TokenServiceServer interface {
+	GetToken(context.Context, *GetTokenRequest) (*TokenResult, error)
+	BatchGetToken(context.Context, *BatchGetTokenRequest) (*BatchGetTokenResponse, error)
+	ForceRefresh(context.Context, *ForceRefreshRequest) (*ForceRefreshResponse, error)
+	WatchTokens(*WatchTokensRequest, TokenService_WatchTokensServer) error
+	ListApps(context.Context, *ListAppsRequest) (*ListAppsResponse, error)
+	Reload(context.Context, *ReloadRequest) (*ReloadResponse, error)
+	mustEmbedUnimplementedTokenServiceServer()
+}
+
+// UnimplementedTokenServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedTokenServiceServer struct {
+}
+
+func (UnimplementedTokenServiceServer) GetToken(context.Context, *GetTokenRequest) (*TokenResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetToken not implemented")
+}
+func (UnimplementedTokenServiceServer) BatchGetToken(context.Context, *BatchGetTokenRequest) (*BatchGetTokenResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchGetToken not implemented")
+}
+func (UnimplementedTokenServiceServer) ForceRefresh(context.Context, *ForceRefreshRequest) (*ForceRefreshResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ForceRefresh not implemented")
+}
+func (UnimplementedTokenServiceServer) WatchTokens(*WatchTokensRequest, TokenService_WatchTokensServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchTokens not implemented")
+}
+func (UnimplementedTokenServiceServer) ListApps(context.Context, *ListAppsRequest) (*ListAppsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListApps not implemented")
+}
+func (UnimplementedTokenServiceServer) Reload(context.Context, *ReloadRequest) (*ReloadResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Reload not implemented")
+}
+func (UnimplementedTokenServiceServer) mustEmbedUnimplementedTokenServiceServer() {}
+
+// UnsafeTokenServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TokenServiceServer will
+// result in compilation errors.
+type UnsafeTokenServiceServer interface {
+	mustEmbedUnimplementedTokenServiceServer()
+}
+
+func RegisterTokenServiceServer(s grpc.ServiceRegistrar, srv TokenServiceServer) {
+	s.RegisterService(&_TokenService_serviceDesc, srv)
+}
+
+func _TokenService_GetToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenServiceServer).GetToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/wechattokenserver.v1.TokenService/GetToken",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenServiceServer).GetToken(ctx, req.(*GetTokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TokenService_BatchGetToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchGetTokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenServiceServer).BatchGetToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/wechattokenserver.v1.TokenService/BatchGetToken",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenServiceServer).BatchGetToken(ctx, req.(*BatchGetTokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TokenService_ForceRefresh_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ForceRefreshRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenServiceServer).ForceRefresh(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/wechattokenserver.v1.TokenService/ForceRefresh",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenServiceServer).ForceRefresh(ctx, req.(*ForceRefreshRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TokenService_WatchTokens_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchTokensRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TokenServiceServer).WatchTokens(m, &tokenServiceWatchTokensServer{stream})
+}
+
+type TokenService_WatchTokensServer interface {
+	Send(*TokenEvent) error
+	grpc.ServerStream
+}
+
+type tokenServiceWatchTokensServer struct {
+	grpc.ServerStream
+}
+
+func (x *tokenServiceWatchTokensServer) Send(m *TokenEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _TokenService_ListApps_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListAppsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenServiceServer).ListApps(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/wechattokenserver.v1.TokenService/ListApps",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenServiceServer).ListApps(ctx, req.(*ListAppsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TokenService_Reload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReloadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenServiceServer).Reload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/wechattokenserver.v1.TokenService/Reload",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenServiceServer).Reload(ctx, req.(*ReloadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _TokenService_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "wechattokenserver.v1.TokenService",
+	HandlerType: (*TokenServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetToken",
+			Handler:    _TokenService_GetToken_Handler,
+		},
+		{
+			MethodName: "BatchGetToken",
+			Handler:    _TokenService_BatchGetToken_Handler,
+		},
+		{
+			MethodName: "ForceRefresh",
+			Handler:    _TokenService_ForceRefresh_Handler,
+		},
+		{
+			MethodName: "ListApps",
+			Handler:    _TokenService_ListApps_Handler,
+		},
+		{
+			MethodName: "Reload",
+			Handler:    _TokenService_Reload_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchTokens",
+			Handler:       _TokenService_WatchTokens_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "tokenserver.proto",
+}
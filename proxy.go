@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"github.com/tidwall/gjson"
+	"github.com/valyala/fasthttp"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"github.com/dbldqt/wechatTokenServer/wechat"
+)
+
+const (
+	PROXY_PREFIX = "/proxy/"
+	PROXY_TIMEOUT = 10*time.Second
+	PROXY_REFRESH_WAIT = 10*time.Second //accessToken失效后等待新accessToken的时间
+	PROXY_REDACTED = "***"
+)
+
+var proxyClient = &fasthttp.Client{}
+
+//微信返回40001，40014，42001时表示accessToken无效或已过期
+func isTokenInvalid(errcode int) bool{
+	return errcode == 40001 || errcode == 40014 || errcode == 42001
+}
+
+//GET|POST /proxy/{appid}/{微信接口路径}，附加当前accessToken后转发到微信接口，accessToken不返回给调用方
+//accessToken无效时强制刷新并重试一次
+func proxyHandler(ctx *fasthttp.RequestCtx){
+	rest := strings.TrimPrefix(string(ctx.Path()),PROXY_PREFIX)
+	index := strings.Index(rest,"/")
+	if index <= 0 || index == len(rest)-1{
+		replyError(ctx,fasthttp.StatusNotFound,ERRCODE_NOT_FOUND,"need /proxy/{appid}/{api path}")
+		return
+	}
+	appid,apiPath := rest[:index],rest[index:]
+	if !ctx.IsGet() && !ctx.IsPost(){
+		ctx.Response.Header.Set("Allow","GET, POST")
+		replyError(ctx,fasthttp.StatusMethodNotAllowed,ERRCODE_METHOD_NOT_ALLOWED,"only get and post supported")
+		return
+	}
+	if !appAuth(ctx){
+		return
+	}
+	wechatman,ok := getWechatMan(ctx)
+	if !ok{
+		return
+	}
+	target,err := wechatman.QueryProxyTarget(appid,appToken(ctx))
+	if err != nil{
+		log.Println(appid+" proxy error "+err.Error())
+		replyError(ctx,0,setWechatError(ctx,err),err.Error())
+		return
+	}
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	if err := proxyDo(ctx,apiPath,target,resp);err != nil{
+		log.Println(appid+" proxy "+apiPath+" error "+err.Error())
+		replyError(ctx,fasthttp.StatusBadGateway,ERRCODE_BAD_GATEWAY,"request wechat api error")
+		return
+	}
+	stale := target.AccessToken
+	if errcode := proxyErrcode(resp.Body());isTokenInvalid(errcode){
+		log.Println(appid+" proxy "+apiPath+" errcode "+strconv.Itoa(errcode)+", refresh accesstoken")
+		if target,err = wechatman.RefreshStaleAccessToken(appid,appToken(ctx),stale,PROXY_REFRESH_WAIT);err != nil{
+			//没有等到新的accessToken，返回微信的原始响应
+			log.Println(appid+" proxy refresh accesstoken error "+err.Error())
+		}else{
+			resp.Reset()
+			if err := proxyDo(ctx,apiPath,target,resp);err != nil{
+				log.Println(appid+" proxy "+apiPath+" retry error "+err.Error())
+				replyError(ctx,fasthttp.StatusBadGateway,ERRCODE_BAD_GATEWAY,"request wechat api error")
+				return
+			}
+		}
+	}
+	ctx.SetStatusCode(resp.StatusCode())
+	ctx.SetContentTypeBytes(resp.Header.ContentType())
+	if disposition := resp.Header.Peek("Content-Disposition");len(disposition) > 0{
+		ctx.Response.Header.SetBytesV("Content-Disposition",disposition)
+	}
+	body := redactToken(resp.Body(),stale)
+	if target != nil{
+		body = redactToken(body,target.AccessToken)
+	}
+	ctx.SetBody(body)
+}
+
+//只转发方法、查询参数、Content-Type和body，调用方传入的accessToken参数被替换
+func buildProxyRequest(ctx *fasthttp.RequestCtx,apiPath string,target *wechat.ProxyTarget,req *fasthttp.Request){
+	args := fasthttp.AcquireArgs()
+	defer fasthttp.ReleaseArgs(args)
+	ctx.QueryArgs().CopyTo(args)
+	args.Del("access_token")
+	args.Del("component_access_token")
+	args.Set(target.TokenParam,target.AccessToken)
+	req.SetRequestURI(target.BaseURL+apiPath+"?"+args.String())
+	req.Header.SetMethodBytes(ctx.Method())
+	if contentType := ctx.Request.Header.ContentType();len(contentType) > 0{
+		req.Header.SetContentTypeBytes(contentType)
+	}
+	req.SetBody(ctx.PostBody())
+}
+
+func proxyDo(ctx *fasthttp.RequestCtx,apiPath string,target *wechat.ProxyTarget,resp *fasthttp.Response) error{
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	buildProxyRequest(ctx,apiPath,target,req)
+	return proxyClient.DoTimeout(req,resp,PROXY_TIMEOUT)
+}
+
+//json响应中的errcode，下载素材等非json响应返回0
+func proxyErrcode(body []byte) int{
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '{'{
+		return 0
+	}
+	return int(gjson.GetBytes(body,"errcode").Int())
+}
+
+func redactToken(body []byte,accessToken string) []byte{
+	if accessToken == ""{
+		return body
+	}
+	return bytes.Replace(body,[]byte(accessToken),[]byte(PROXY_REDACTED),-1)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"github.com/dbldqt/wechatTokenServer/wechat"
+	"github.com/valyala/fasthttp"
+	"testing"
+)
+
+func TestBuildProxyRequest(test *testing.T){
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod("POST")
+	ctx.Request.SetRequestURI("/proxy/wx_test/cgi-bin/message/template/send?access_token=client&lang=zh_CN")
+	ctx.Request.Header.SetContentType("application/json")
+	ctx.Request.Header.Set(APP_TOKEN_HEADER,"token")
+	ctx.Request.SetBodyString(`{"touser":"openid"}`)
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	target := &wechat.ProxyTarget{BaseURL:wechat.WECHAT_API_BASE,TokenParam:"access_token",AccessToken:"real"}
+	buildProxyRequest(ctx,"/cgi-bin/message/template/send",target,req)
+	if uri := req.URI().String();uri != "https://api.weixin.qq.com/cgi-bin/message/template/send?lang=zh_CN&access_token=real"{
+		test.Error("proxy uri error",uri)
+	}
+	if string(req.Header.Method()) != "POST" || string(req.Header.ContentType()) != "application/json" || string(req.Body()) != `{"touser":"openid"}`{
+		test.Error("proxy request error",req.String())
+	}
+	if len(req.Header.Peek(APP_TOKEN_HEADER)) != 0{
+		test.Error("app token forwarded to wechat")
+	}
+}
+
+func TestProxyResponse(test *testing.T){
+	cases := []struct{
+		body    string
+		errcode int
+		invalid bool
+	}{
+		{`{"errcode":0,"errmsg":"ok"}`,0,false},
+		{` {"errcode":40001,"errmsg":"invalid credential"}`,40001,true},
+		{`{"errcode":40014,"errmsg":"invalid access_token"}`,40014,true},
+		{`{"errcode":42001,"errmsg":"access_token expired"}`,42001,true},
+		{`{"errcode":45009,"errmsg":"reach max api daily quota limit"}`,45009,false},
+		{"\x89PNG",0,false},
+	}
+	for _,c := range cases{
+		errcode := proxyErrcode([]byte(c.body))
+		if errcode != c.errcode || isTokenInvalid(errcode) != c.invalid{
+			test.Error("proxy errcode error",c.body,errcode)
+		}
+	}
+	if body := string(redactToken([]byte(`{"errmsg":"invalid credential real"}`),"real"));body != `{"errmsg":"invalid credential `+PROXY_REDACTED+`"}`{
+		test.Error("redact token error",body)
+	}
+}
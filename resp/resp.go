@@ -0,0 +1,184 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//简单的redis客户端，实现RESP协议，只维护一个连接，命令串行执行
+
+const (
+	TIMEOUT = 3*time.Second
+)
+
+//redis返回的错误，连接本身是正常的
+type Error string
+
+func (re Error) Error() string{
+	return "redis error "+string(re)
+}
+
+type Client struct {
+	sync.Mutex
+	addr     string
+	password string
+	db       int
+	conn     net.Conn
+	reader   *bufio.Reader
+}
+
+func NewClient(addr,password string,db int) *Client{
+	return &Client{
+		addr:addr,
+		password:password,
+		db:db,
+	}
+}
+
+func (c *Client) connect() error{
+	conn,err := net.DialTimeout("tcp",c.addr,TIMEOUT)
+	if err != nil{
+		return err
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+	if c.password != ""{
+		if _,err := c.command("AUTH",c.password);err != nil{
+			c.close()
+			return err
+		}
+	}
+	if c.db != 0{
+		if _,err := c.command("SELECT",strconv.Itoa(c.db));err != nil{
+			c.close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) close(){
+	if c.conn != nil{
+		c.conn.Close()
+	}
+	c.conn = nil
+	c.reader = nil
+}
+
+func (c *Client) Close(){
+	c.Lock()
+	c.close()
+	c.Unlock()
+}
+
+//执行redis命令，连接异常时重连重试一次
+//返回值：简单字符串和bulk string为string，整数为int64，nil为nil，数组为[]interface{}
+func (c *Client) Do(args ...string) (interface{},error){
+	c.Lock()
+	defer c.Unlock()
+	var err error
+	for i := 0;i < 2;i++{
+		if c.conn == nil{
+			if err = c.connect();err != nil{
+				continue
+			}
+		}
+		var reply interface{}
+		reply,err = c.command(args...)
+		if _,ok := err.(Error);ok{
+			return nil,err
+		}
+		if err == nil{
+			return reply,nil
+		}
+		c.close()
+	}
+	return nil,err
+}
+
+func (c *Client) command(args ...string) (interface{},error){
+	c.conn.SetDeadline(time.Now().Add(TIMEOUT))
+	if _,err := c.conn.Write(EncodeCommand(args...));err != nil{
+		return nil,err
+	}
+	return ReadReply(c.reader)
+}
+
+func EncodeCommand(args ...string) []byte{
+	buf := make([]byte,0,64)
+	buf = append(buf,'*')
+	buf = strconv.AppendInt(buf,int64(len(args)),10)
+	buf = append(buf,'\r','\n')
+	for _,arg := range args{
+		buf = append(buf,'$')
+		buf = strconv.AppendInt(buf,int64(len(arg)),10)
+		buf = append(buf,'\r','\n')
+		buf = append(buf,arg...)
+		buf = append(buf,'\r','\n')
+	}
+	return buf
+}
+
+func readLine(reader *bufio.Reader) (string,error){
+	line,err := reader.ReadString('\n')
+	if err != nil{
+		return "",err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r'{
+		return "",errors.New("redis protocol error")
+	}
+	return line[:len(line)-2],nil
+}
+
+//解析RESP协议的数据，同时用于测试中的redis服务解析命令
+func ReadReply(reader *bufio.Reader) (interface{},error){
+	line,err := readLine(reader)
+	if err != nil{
+		return nil,err
+	}
+	if len(line) == 0{
+		return nil,errors.New("redis protocol error")
+	}
+	switch line[0]{
+	case '+':
+		return line[1:],nil
+	case '-':
+		return nil,Error(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:],10,64)
+	case '$':
+		size,err := strconv.Atoi(line[1:])
+		if err != nil{
+			return nil,err
+		}
+		if size < 0{
+			return nil,nil
+		}
+		data := make([]byte,size+2)
+		if _,err := io.ReadFull(reader,data);err != nil{
+			return nil,err
+		}
+		return string(data[:size]),nil
+	case '*':
+		size,err := strconv.Atoi(line[1:])
+		if err != nil{
+			return nil,err
+		}
+		if size < 0{
+			return nil,nil
+		}
+		items := make([]interface{},size)
+		for i := range items{
+			if items[i],err = ReadReply(reader);err != nil{
+				return nil,err
+			}
+		}
+		return items,nil
+	}
+	return nil,errors.New("redis protocol error")
+}
@@ -0,0 +1,176 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+//本地状态文件及配置文件中敏感字段的加密，使用AES-256-GCM，密钥由主密钥sha256得到
+//主密钥优先从环境变量读取，其次从密钥文件读取，未设置主密钥时文件按明文读写
+
+const (
+	MASTER_KEY_ENV = "WECHATMAN_MASTER_KEY"            //主密钥环境变量
+	MASTER_KEY_FILE_ENV = "WECHATMAN_MASTER_KEY_FILE"  //主密钥文件环境变量
+	ENCRYPTED_PREFIX = "enc:"                          //配置文件中加密字段的前缀
+)
+
+//加密文件头，用于区分加密文件和旧的明文文件
+var fileMagic = []byte("WECHATMAN-ENC1\n")
+
+var keyLocker sync.RWMutex
+var masterKey []byte
+
+func SetMasterKey(key string){
+	keyLocker.Lock()
+	defer keyLocker.Unlock()
+	if key == ""{
+		masterKey = nil
+		return
+	}
+	sum := sha256.Sum256([]byte(key))
+	masterKey = sum[:]
+}
+
+//加载主密钥，顺序为环境变量WECHATMAN_MASTER_KEY，环境变量WECHATMAN_MASTER_KEY_FILE指定的文件，keyFile
+func LoadMasterKey(keyFile string) error{
+	if key := os.Getenv(MASTER_KEY_ENV);key != ""{
+		SetMasterKey(key)
+		return nil
+	}
+	if file := os.Getenv(MASTER_KEY_FILE_ENV);file != ""{
+		keyFile = file
+	}
+	if keyFile == ""{
+		return nil
+	}
+	content,err := ioutil.ReadFile(keyFile)
+	if err != nil{
+		return err
+	}
+	key := strings.TrimSpace(string(content))
+	if key == ""{
+		return errors.New("master key file "+keyFile+" is empty")
+	}
+	SetMasterKey(key)
+	return nil
+}
+
+func HasMasterKey() bool{
+	keyLocker.RLock()
+	defer keyLocker.RUnlock()
+	return masterKey != nil
+}
+
+func newGCM() (cipher.AEAD,error){
+	keyLocker.RLock()
+	key := masterKey
+	keyLocker.RUnlock()
+	if key == nil{
+		return nil,errors.New("master key not set")
+	}
+	block,err := aes.NewCipher(key)
+	if err != nil{
+		return nil,err
+	}
+	return cipher.NewGCM(block)
+}
+
+//加密结果为nonce+密文
+func Encrypt(plain []byte) ([]byte,error){
+	gcm,err := newGCM()
+	if err != nil{
+		return nil,err
+	}
+	nonce := make([]byte,gcm.NonceSize())
+	if _,err := rand.Read(nonce);err != nil{
+		return nil,err
+	}
+	return gcm.Seal(nonce,nonce,plain,nil),nil
+}
+
+func Decrypt(data []byte) ([]byte,error){
+	gcm,err := newGCM()
+	if err != nil{
+		return nil,err
+	}
+	if len(data) < gcm.NonceSize(){
+		return nil,errors.New("encrypted data too short")
+	}
+	return gcm.Open(nil,data[:gcm.NonceSize()],data[gcm.NonceSize():],nil)
+}
+
+func IsEncrypted(value string) bool{
+	return strings.HasPrefix(value,ENCRYPTED_PREFIX)
+}
+
+//加密配置字段，结果为enc:+base64
+func EncryptString(value string) (string,error){
+	data,err := Encrypt([]byte(value))
+	if err != nil{
+		return "",err
+	}
+	return ENCRYPTED_PREFIX+base64.StdEncoding.EncodeToString(data),nil
+}
+
+//解密配置字段，没有enc:前缀的值原样返回
+func DecryptString(value string) (string,error){
+	if !IsEncrypted(value){
+		return value,nil
+	}
+	data,err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value,ENCRYPTED_PREFIX))
+	if err != nil{
+		return "",err
+	}
+	plain,err := Decrypt(data)
+	if err != nil{
+		return "",err
+	}
+	return string(plain),nil
+}
+
+//加密需要落盘的数据，设置了主密钥时加密并加上文件头，否则原样返回
+func Seal(data []byte) ([]byte,error){
+	if !HasMasterKey(){
+		return data,nil
+	}
+	encrypted,err := Encrypt(data)
+	if err != nil{
+		return nil,err
+	}
+	return append(append([]byte(nil),fileMagic...),encrypted...),nil
+}
+
+//解密Seal的结果，兼容未加密的旧数据
+func Open(data []byte) ([]byte,error){
+	if !bytes.HasPrefix(data,fileMagic){
+		return data,nil
+	}
+	return Decrypt(data[len(fileMagic):])
+}
+
+//写入本地状态文件，设置了主密钥时加密写入
+func WriteFile(filename string,data []byte,perm os.FileMode) error{
+	sealed,err := Seal(data)
+	if err != nil{
+		return err
+	}
+	return ioutil.WriteFile(filename,sealed,perm)
+}
+
+//读取本地状态文件，兼容未加密的旧文件
+func ReadFile(filename string) ([]byte,error){
+	content,err := ioutil.ReadFile(filename)
+	if err != nil{
+		return nil,err
+	}
+	return Open(content)
+}
@@ -0,0 +1,65 @@
+package secure
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptString(test *testing.T){
+	SetMasterKey("test master key")
+	defer SetMasterKey("")
+
+	encrypted,err := EncryptString("appsecret")
+	if err != nil{
+		test.Fatal("encrypt error "+err.Error())
+	}
+	if !IsEncrypted(encrypted){
+		test.Error("encrypted value should have prefix")
+	}
+	plain,err := DecryptString(encrypted)
+	if err != nil || plain != "appsecret"{
+		test.Error("decrypt value error")
+	}
+
+	if plain,err := DecryptString("plain");err != nil || plain != "plain"{
+		test.Error("plain value should be returned as is")
+	}
+
+	SetMasterKey("other key")
+	if _,err := DecryptString(encrypted);err == nil{
+		test.Error("decrypt with wrong key should error")
+	}
+}
+
+func TestFile(test *testing.T){
+	dir,err := ioutil.TempDir("","secure")
+	if err != nil{
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir,"state")
+
+	//未设置主密钥时明文读写
+	SetMasterKey("")
+	if err := WriteFile(file,[]byte("token"),0600);err != nil{
+		test.Fatal(err)
+	}
+	SetMasterKey("test master key")
+	defer SetMasterKey("")
+	if content,err := ReadFile(file);err != nil || string(content) != "token"{
+		test.Error("read plain file error")
+	}
+
+	if err := WriteFile(file,[]byte("token"),0600);err != nil{
+		test.Fatal(err)
+	}
+	raw,_ := ioutil.ReadFile(file)
+	if string(raw) == "token"{
+		test.Error("file should be encrypted")
+	}
+	if content,err := ReadFile(file);err != nil || string(content) != "token"{
+		test.Error("read encrypted file error")
+	}
+}
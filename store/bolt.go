@@ -0,0 +1,131 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/dbldqt/wechatTokenServer/secure"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+const (
+	BOLT_BUCKET = "access_token"
+	BOLT_OPEN_TIMEOUT = 3*time.Second
+)
+
+//基于bbolt的本地kv存储，每次操作时打开数据库，操作完成立即关闭，
+//bbolt同一时间只允许一个进程持有写锁，这样其他进程可以在间隙读取，设置了主密钥时value加密保存
+type BoltStore struct {
+	path string
+}
+
+func NewBoltStore(path string) (*BoltStore,error){
+	if path == ""{
+		return nil,errors.New("bolt store need a file path")
+	}
+	bs := &BoltStore{path:path}
+	err := bs.update(func(bucket *bolt.Bucket) error{
+		return nil
+	})
+	if err != nil{
+		return nil,err
+	}
+	return bs,nil
+}
+
+func (bs *BoltStore) update(fn func(bucket *bolt.Bucket) error) error{
+	db,err := bolt.Open(bs.path,0600,&bolt.Options{Timeout:BOLT_OPEN_TIMEOUT})
+	if err != nil{
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(tx *bolt.Tx) error{
+		bucket,err := tx.CreateBucketIfNotExists([]byte(BOLT_BUCKET))
+		if err != nil{
+			return err
+		}
+		return fn(bucket)
+	})
+}
+
+func (bs *BoltStore) view(fn func(bucket *bolt.Bucket) error) error{
+	db,err := bolt.Open(bs.path,0600,&bolt.Options{Timeout:BOLT_OPEN_TIMEOUT,ReadOnly:true})
+	if err != nil{
+		return err
+	}
+	defer db.Close()
+	return db.View(func(tx *bolt.Tx) error{
+		bucket := tx.Bucket([]byte(BOLT_BUCKET))
+		if bucket == nil{
+			return nil
+		}
+		return fn(bucket)
+	})
+}
+
+func decodeToken(value []byte) (*Token,error){
+	plain,err := secure.Open(value)
+	if err != nil{
+		return nil,err
+	}
+	token := &Token{}
+	if err := json.Unmarshal(plain,token);err != nil{
+		return nil,err
+	}
+	return token,nil
+}
+
+func (bs *BoltStore) Get(componentAppID,appid string) (*Token,error){
+	var token *Token
+	err := bs.view(func(bucket *bolt.Bucket) error{
+		value := bucket.Get([]byte(TokenKey(componentAppID,appid)))
+		if value == nil{
+			return nil
+		}
+		var err error
+		token,err = decodeToken(value)
+		return err
+	})
+	if err != nil{
+		return nil,err
+	}
+	if token == nil{
+		return nil,ErrNotFound
+	}
+	return token,nil
+}
+
+func (bs *BoltStore) Put(token *Token) error{
+	content,err := json.Marshal(token)
+	if err != nil{
+		return err
+	}
+	sealed,err := secure.Seal(content)
+	if err != nil{
+		return err
+	}
+	return bs.update(func(bucket *bolt.Bucket) error{
+		return bucket.Put([]byte(token.Key()),sealed)
+	})
+}
+
+func (bs *BoltStore) Delete(componentAppID,appid string) error{
+	return bs.update(func(bucket *bolt.Bucket) error{
+		return bucket.Delete([]byte(TokenKey(componentAppID,appid)))
+	})
+}
+
+func (bs *BoltStore) List() ([]*Token,error){
+	tokens := make([]*Token,0)
+	err := bs.view(func(bucket *bolt.Bucket) error{
+		return bucket.ForEach(func(key,value []byte) error{
+			token,err := decodeToken(value)
+			if err != nil{
+				return err
+			}
+			tokens = append(tokens,token)
+			return nil
+		})
+	})
+	return tokens,err
+}
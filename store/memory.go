@@ -0,0 +1,52 @@
+package store
+
+import "sync"
+
+//内存存储，进程退出后丢失
+type MemoryStore struct {
+	sync.RWMutex
+	tokens map[string]*Token
+}
+
+func NewMemoryStore() *MemoryStore{
+	return &MemoryStore{
+		tokens:make(map[string]*Token),
+	}
+}
+
+func (ms *MemoryStore) Get(componentAppID,appid string) (*Token,error){
+	ms.RLock()
+	defer ms.RUnlock()
+	token,ok := ms.tokens[TokenKey(componentAppID,appid)]
+	if !ok{
+		return nil,ErrNotFound
+	}
+	copied := *token
+	return &copied,nil
+}
+
+func (ms *MemoryStore) Put(token *Token) error{
+	copied := *token
+	ms.Lock()
+	ms.tokens[token.Key()] = &copied
+	ms.Unlock()
+	return nil
+}
+
+func (ms *MemoryStore) Delete(componentAppID,appid string) error{
+	ms.Lock()
+	delete(ms.tokens,TokenKey(componentAppID,appid))
+	ms.Unlock()
+	return nil
+}
+
+func (ms *MemoryStore) List() ([]*Token,error){
+	ms.RLock()
+	defer ms.RUnlock()
+	tokens := make([]*Token,0,len(ms.tokens))
+	for _,token := range ms.tokens{
+		copied := *token
+		tokens = append(tokens,&copied)
+	}
+	return tokens,nil
+}
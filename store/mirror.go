@@ -0,0 +1,42 @@
+package store
+
+import "log"
+
+//镜像存储，读取使用主存储，写入同时同步到所有镜像存储，镜像写入失败只记录日志
+type MirrorStore struct {
+	primary TokenStore
+	mirrors []TokenStore
+}
+
+func NewMirrorStore(primary TokenStore,mirrors ...TokenStore) *MirrorStore{
+	return &MirrorStore{
+		primary:primary,
+		mirrors:mirrors,
+	}
+}
+
+func (ms *MirrorStore) Get(componentAppID,appid string) (*Token,error){
+	return ms.primary.Get(componentAppID,appid)
+}
+
+func (ms *MirrorStore) Put(token *Token) error{
+	for _,mirror := range ms.mirrors{
+		if err := mirror.Put(token);err != nil{
+			log.Println(token.Key()+" mirror token error "+err.Error())
+		}
+	}
+	return ms.primary.Put(token)
+}
+
+func (ms *MirrorStore) Delete(componentAppID,appid string) error{
+	for _,mirror := range ms.mirrors{
+		if err := mirror.Delete(componentAppID,appid);err != nil{
+			log.Println(TokenKey(componentAppID,appid)+" mirror delete token error "+err.Error())
+		}
+	}
+	return ms.primary.Delete(componentAppID,appid)
+}
+
+func (ms *MirrorStore) List() ([]*Token,error){
+	return ms.primary.List()
+}
@@ -0,0 +1,128 @@
+package store
+
+import (
+	"errors"
+	"github.com/dbldqt/wechatTokenServer/resp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	REDIS_DEFAULT_KEY_TEMPLATE = "wechat:access_token:{appid}"
+	REDIS_APPID_PLACEHOLDER = "{appid}"
+)
+
+//redis存储，value直接保存accessToken字符串，过期时间为accessToken剩余有效期，方便其他语言的服务直接读取
+type RedisStore struct {
+	client      *resp.Client
+	keyTemplate string
+}
+
+//keyTemplate中的{appid}会替换为appid，授权方替换为第三方平台appid:授权方appid，为空时使用wechat:access_token:{appid}
+func NewRedisStore(addr,password string,db int,keyTemplate string) (*RedisStore,error){
+	if addr == ""{
+		return nil,errors.New("redis store need an address")
+	}
+	if keyTemplate == ""{
+		keyTemplate = REDIS_DEFAULT_KEY_TEMPLATE
+	}
+	if strings.Count(keyTemplate,REDIS_APPID_PLACEHOLDER) != 1{
+		return nil,errors.New("redis key template must contain one "+REDIS_APPID_PLACEHOLDER)
+	}
+	return &RedisStore{
+		client:resp.NewClient(addr,password,db),
+		keyTemplate:keyTemplate,
+	},nil
+}
+
+func (rs *RedisStore) key(componentAppID,appid string) string{
+	return strings.Replace(rs.keyTemplate,REDIS_APPID_PLACEHOLDER,TokenKey(componentAppID,appid),1)
+}
+
+//从redis key中解析TokenKey，不符合模板的key返回空
+func (rs *RedisStore) tokenKey(key string) string{
+	i := strings.Index(rs.keyTemplate,REDIS_APPID_PLACEHOLDER)
+	prefix := rs.keyTemplate[:i]
+	suffix := rs.keyTemplate[i+len(REDIS_APPID_PLACEHOLDER):]
+	if len(key) <= len(prefix)+len(suffix) || !strings.HasPrefix(key,prefix) || !strings.HasSuffix(key,suffix){
+		return ""
+	}
+	return key[len(prefix):len(key)-len(suffix)]
+}
+
+func (rs *RedisStore) Get(componentAppID,appid string) (*Token,error){
+	reply,err := rs.client.Do("GET",rs.key(componentAppID,appid))
+	if err != nil{
+		return nil,err
+	}
+	accessToken,ok := reply.(string)
+	if !ok{
+		return nil,ErrNotFound
+	}
+	reply,err = rs.client.Do("TTL",rs.key(componentAppID,appid))
+	if err != nil{
+		return nil,err
+	}
+	ttl,_ := reply.(int64)
+	if ttl <= 0{
+		return nil,ErrNotFound
+	}
+	return &Token{
+		AppID:appid,
+		ComponentAppID:componentAppID,
+		AccessToken:accessToken,
+		ExpireAt:time.Now().Unix()+ttl,
+	},nil
+}
+
+//过期时间为accessToken剩余有效期，已经过期的token直接删除
+func (rs *RedisStore) Put(token *Token) error{
+	ttl := token.ExpireAt-time.Now().Unix()
+	if ttl <= 0{
+		return rs.Delete(token.ComponentAppID,token.AppID)
+	}
+	_,err := rs.client.Do("SET",rs.key(token.ComponentAppID,token.AppID),token.AccessToken,"EX",strconv.FormatInt(ttl,10))
+	return err
+}
+
+func (rs *RedisStore) Delete(componentAppID,appid string) error{
+	_,err := rs.client.Do("DEL",rs.key(componentAppID,appid))
+	return err
+}
+
+func (rs *RedisStore) List() ([]*Token,error){
+	pattern := strings.Replace(rs.keyTemplate,REDIS_APPID_PLACEHOLDER,"*",1)
+	tokens := make([]*Token,0)
+	cursor := "0"
+	for{
+		reply,err := rs.client.Do("SCAN",cursor,"MATCH",pattern,"COUNT","100")
+		if err != nil{
+			return nil,err
+		}
+		items,ok := reply.([]interface{})
+		if !ok || len(items) != 2{
+			return nil,errors.New("redis scan reply error")
+		}
+		cursor,_ = items[0].(string)
+		keys,_ := items[1].([]interface{})
+		for _,key := range keys{
+			tokenKey := rs.tokenKey(key.(string))
+			if tokenKey == ""{
+				continue
+			}
+			token,err := rs.Get(ParseTokenKey(tokenKey))
+			if err == ErrNotFound{
+				continue
+			}
+			if err != nil{
+				return nil,err
+			}
+			tokens = append(tokens,token)
+		}
+		if cursor == "0" || cursor == ""{
+			break
+		}
+	}
+	return tokens,nil
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/dbldqt/wechatTokenServer/resp"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//进程内的RESP服务，只实现RedisStore用到的命令
+type fakeRedis struct {
+	sync.Mutex
+	listener net.Listener
+	values   map[string]string
+	expireAt map[string]time.Time
+}
+
+func newFakeRedis(test *testing.T) *fakeRedis{
+	listener,err := net.Listen("tcp","127.0.0.1:0")
+	if err != nil{
+		test.Fatal(err)
+	}
+	fr := &fakeRedis{
+		listener:listener,
+		values:make(map[string]string),
+		expireAt:make(map[string]time.Time),
+	}
+	go func(){
+		for{
+			conn,err := listener.Accept()
+			if err != nil{
+				return
+			}
+			go fr.serve(conn)
+		}
+	}()
+	return fr
+}
+
+func (fr *fakeRedis) serve(conn net.Conn){
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for{
+		reply,err := resp.ReadReply(reader)
+		if err != nil{
+			return
+		}
+		items := reply.([]interface{})
+		args := make([]string,len(items))
+		for i,item := range items{
+			args[i] = item.(string)
+		}
+		conn.Write([]byte(fr.handle(args)))
+	}
+}
+
+func bulk(value string) string{
+	return "$"+strconv.Itoa(len(value))+"\r\n"+value+"\r\n"
+}
+
+func (fr *fakeRedis) handle(args []string) string{
+	fr.Lock()
+	defer fr.Unlock()
+	switch strings.ToUpper(args[0]){
+	case "SET":
+		fr.values[args[1]] = args[2]
+		if len(args) == 5{
+			ttl,_ := strconv.Atoi(args[4])
+			fr.expireAt[args[1]] = time.Now().Add(time.Duration(ttl)*time.Second)
+		}
+		return "+OK\r\n"
+	case "GET":
+		value,ok := fr.values[args[1]]
+		if !ok{
+			return "$-1\r\n"
+		}
+		return bulk(value)
+	case "TTL":
+		if _,ok := fr.values[args[1]];!ok{
+			return ":-2\r\n"
+		}
+		return fmt.Sprintf(":%d\r\n",int64(time.Until(fr.expireAt[args[1]])/time.Second)+1)
+	case "DEL":
+		delete(fr.values,args[1])
+		delete(fr.expireAt,args[1])
+		return ":1\r\n"
+	case "SCAN":
+		keys := make([]string,0)
+		for key := range fr.values{
+			if ok,_ := path.Match(args[3],key);ok{
+				keys = append(keys,bulk(key))
+			}
+		}
+		return "*2\r\n"+bulk("0")+"*"+strconv.Itoa(len(keys))+"\r\n"+strings.Join(keys,"")
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func TestRedisStore(test *testing.T){
+	fr := newFakeRedis(test)
+	defer fr.listener.Close()
+
+	rs,err := NewRedisStore(fr.listener.Addr().String(),"",0,"")
+	if err != nil{
+		test.Fatal(err)
+	}
+	testTokenStore(test,rs)
+
+	rs.Put(&Token{AppID:"wx_redis",AccessToken:"redis_token",ExpireAt:time.Now().Unix()+100})
+	if fr.values["wechat:access_token:wx_redis"] != "redis_token"{
+		test.Error("redis key template error")
+	}
+	if ttl := time.Until(fr.expireAt["wechat:access_token:wx_redis"]);ttl > 100*time.Second || ttl < 98*time.Second{
+		test.Error("redis ttl should be remaining lifetime")
+	}
+
+	rs.Put(&Token{AppID:"wx_redis",ComponentAppID:"wx_component",AccessToken:"authorizer_token",ExpireAt:time.Now().Unix()+100})
+	if fr.values["wechat:access_token:wx_component:wx_redis"] != "authorizer_token"{
+		test.Error("authorizer redis key error")
+	}
+
+	if _,err := NewRedisStore(fr.listener.Addr().String(),"",0,"wechat:token");err == nil{
+		test.Error("key template without appid should error")
+	}
+}
+
+func TestMirrorStore(test *testing.T){
+	primary := NewMemoryStore()
+	mirror := NewMemoryStore()
+	ms := NewMirrorStore(primary,mirror)
+	testTokenStore(test,ms)
+
+	ms.Put(&Token{AppID:"wx_mirror",AccessToken:"mirror_token",ExpireAt:time.Now().Unix()+100})
+	if token,err := mirror.Get("","wx_mirror");err != nil || token.AccessToken != "mirror_token"{
+		test.Error("token should be mirrored")
+	}
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	STORE_MEMORY = "memory"
+	STORE_BOLT = "bolt"
+)
+
+var ErrNotFound = errors.New("token not found")
+
+//保存到TokenStore中的accessToken
+type Token struct {
+	AppID          string `json:"appid"`
+	ComponentAppID string `json:"componentAppId,omitempty"`  //第三方平台授权方所属的第三方平台appid
+	AccessToken    string `json:"accessToken"`
+	UpdateTime     int64  `json:"updateTime"`
+	ExpireAt       int64  `json:"expireAt"`  //accessToken真实的过期时间
+}
+
+func (t *Token) Expired() bool{
+	return time.Now().Unix() >= t.ExpireAt
+}
+
+func (t *Token) Key() string{
+	return TokenKey(t.ComponentAppID,t.AppID)
+}
+
+//存储中的key，授权方为第三方平台appid:授权方appid，与直接配置的同一appid的应用区分
+func TokenKey(componentAppID,appid string) string{
+	if componentAppID == ""{
+		return appid
+	}
+	return componentAppID+":"+appid
+}
+
+//从key中解析第三方平台appid和应用appid
+func ParseTokenKey(key string) (string,string){
+	if i := strings.Index(key,":");i >= 0{
+		return key[:i],key[i+1:]
+	}
+	return "",key
+}
+
+//accessToken存储，WechatMan每次刷新后写入，其他进程或者备份工具可以直接读取
+//授权方使用所属第三方平台的appid区分，直接配置的应用componentAppID为空
+type TokenStore interface {
+	Get(componentAppID,appid string) (*Token,error)
+	Put(token *Token) error
+	Delete(componentAppID,appid string) error
+	List() ([]*Token,error)
+}
+
+//根据配置创建TokenStore，storeType为空时使用内存存储
+func NewTokenStore(storeType,path string) (TokenStore,error){
+	switch storeType{
+	case "",STORE_MEMORY:
+		return NewMemoryStore(),nil
+	case STORE_BOLT:
+		return NewBoltStore(path)
+	default:
+		return nil,errors.New("unknown token store "+storeType)
+	}
+}
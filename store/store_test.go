@@ -0,0 +1,72 @@
+package store
+
+import (
+	"github.com/dbldqt/wechatTokenServer/secure"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testTokenStore(test *testing.T,ts TokenStore){
+	token := &Token{
+		AppID:"wx_store",
+		AccessToken:"store_token",
+		UpdateTime:time.Now().Unix(),
+		ExpireAt:time.Now().Unix()+7200,
+	}
+	if err := ts.Put(token);err != nil{
+		test.Fatal("put token error "+err.Error())
+	}
+	got,err := ts.Get("","wx_store")
+	if err != nil || got.AccessToken != "store_token" || got.ExpireAt != token.ExpireAt{
+		test.Error("get token error")
+	}
+	//同一appid的授权方与直接配置的应用分别保存
+	authorizer := &Token{
+		AppID:"wx_store",
+		ComponentAppID:"wx_component",
+		AccessToken:"authorizer_token",
+		UpdateTime:time.Now().Unix(),
+		ExpireAt:time.Now().Unix()+7200,
+	}
+	if err := ts.Put(authorizer);err != nil{
+		test.Fatal("put authorizer token error "+err.Error())
+	}
+	tokens,err := ts.List()
+	if err != nil || len(tokens) != 2{
+		test.Error("list token error")
+	}
+	if err := ts.Delete("","wx_store");err != nil{
+		test.Fatal("delete token error "+err.Error())
+	}
+	if _,err := ts.Get("","wx_store");err != ErrNotFound{
+		test.Error("deleted token should not found")
+	}
+	got,err = ts.Get("wx_component","wx_store")
+	if err != nil || got.AccessToken != "authorizer_token" || got.ComponentAppID != "wx_component"{
+		test.Error("authorizer token should not be deleted")
+	}
+	ts.Delete("wx_component","wx_store")
+}
+
+func TestMemoryStore(test *testing.T){
+	testTokenStore(test,NewMemoryStore())
+}
+
+func TestBoltStore(test *testing.T){
+	dir,err := ioutil.TempDir("","store")
+	if err != nil{
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	secure.SetMasterKey("test master key")
+	defer secure.SetMasterKey("")
+	ts,err := NewBoltStore(filepath.Join(dir,"token.db"))
+	if err != nil{
+		test.Fatal("open bolt store error "+err.Error())
+	}
+	testTokenStore(test,ts)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/valyala/fasthttp"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"github.com/dbldqt/wechatTokenServer/wechat"
+)
+
+const (
+	V1_PREFIX = "/v1/"
+	APP_TOKEN_HEADER = "X-App-Token"     //应用的查询校验token
+	ADMIN_TOKEN_HEADER = "X-Admin-Token" //管理接口的AdminToken
+	MAX_BATCH_SIZE = 100                 //批量查询单次最多appid数量
+	CURRENT_TOKEN_HEADER = "X-Current-Token" //长轮询时调用方持有的accessToken
+	DEFAULT_WAIT_TIMEOUT = 30            //长轮询默认超时时间，秒
+	MAX_WAIT_TIMEOUT = 60
+	ERRCODE_NOT_MODIFIED = 30400         //长轮询超时，没有更新的accessToken
+)
+
+type AppStatusResult struct{
+	Errcode    int               `json:"errcode"`
+	Msg        string            `json:"msg"`
+	ServerTime int64             `json:"serverTime"`
+	App        *wechat.AppStatus `json:"app"`
+}
+
+type WaitResult struct{
+	Result
+	UpdateTime int64 `json:"updateTime"`
+}
+
+type BatchTokenResult struct{
+	AppID       string `json:"appid"`
+	AccessToken string `json:"accessToken"`
+	ExpireAt    int64  `json:"expireAt"`
+	Errcode     int    `json:"errcode"`
+	Msg         string `json:"msg"`
+}
+
+type BatchResult struct{
+	Errcode    int                 `json:"errcode"`
+	Msg        string              `json:"msg"`
+	ServerTime int64               `json:"serverTime"`
+	Tokens     []*BatchTokenResult `json:"tokens"`
+}
+
+type AppListResult struct{
+	Errcode    int                 `json:"errcode"`
+	Msg        string              `json:"msg"`
+	ServerTime int64               `json:"serverTime"`
+	Apps       []*wechat.AppStatus `json:"apps"`
+}
+
+//v1接口，凭证通过请求头传递，避免出现在访问日志的url中
+//GET  /v1/apps/{appid}               应用状态
+//GET  /v1/apps/{appid}/token         查询accessToken
+//POST /v1/apps/{appid}/token/refresh 强制刷新accessToken
+//GET  /v1/apps/{appid}/token/wait    长轮询等待更新的accessToken
+//GET  /v1/apps/{appid}/events        订阅accessToken变化(SSE)
+//POST /v1/tokens/batch               批量查询accessToken
+//GET  /v1/ws                         websocket订阅多个应用的事件
+//GET  /v1/admin/apps                 列出所有应用
+//POST /v1/admin/reload               重新加载配置文件
+func v1Handler(ctx *fasthttp.RequestCtx){
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(string(ctx.Path()),V1_PREFIX),"/"),"/")
+	switch{
+	case len(parts) >= 2 && parts[0] == "apps" && parts[1] != "":
+		appid := parts[1]
+		switch strings.Join(parts[2:],"/"){
+		case "":
+			if allowMethod(ctx,"GET") && appAuth(ctx){
+				appStatusHandler(ctx,appid)
+			}
+		case "token":
+			if allowMethod(ctx,"GET") && appAuth(ctx){
+				replyAccessToken(ctx,appid,appToken(ctx))
+			}
+		case "token/refresh":
+			if allowMethod(ctx,"POST") && appAuth(ctx){
+				replyForceRefresh(ctx,appid,appToken(ctx))
+			}
+		case "token/wait":
+			if allowMethod(ctx,"GET") && appAuth(ctx){
+				waitTokenHandler(ctx,appid)
+			}
+		case "events":
+			if allowMethod(ctx,"GET") && appAuth(ctx){
+				eventStreamHandler(ctx,appid)
+			}
+		default:
+			replyError(ctx,fasthttp.StatusNotFound,ERRCODE_NOT_FOUND,"no this route")
+		}
+	case len(parts) == 1 && parts[0] == "ws":
+		if allowMethod(ctx,"GET") && queryIpAuth(ctx){
+			wsHandler(ctx)
+		}
+	case len(parts) == 2 && parts[0] == "tokens" && parts[1] == "batch":
+		if allowMethod(ctx,"POST") && queryIpAuth(ctx){
+			batchTokenHandler(ctx)
+		}
+	case len(parts) == 2 && parts[0] == "admin" && parts[1] == "apps":
+		if allowMethod(ctx,"GET") && adminAuth(ctx,string(ctx.Request.Header.Peek(ADMIN_TOKEN_HEADER))){
+			appListHandler(ctx)
+		}
+	case len(parts) == 2 && parts[0] == "admin" && parts[1] == "reload":
+		if allowMethod(ctx,"POST") && adminAuth(ctx,string(ctx.Request.Header.Peek(ADMIN_TOKEN_HEADER))){
+			replyReload(ctx)
+		}
+	default:
+		replyError(ctx,fasthttp.StatusNotFound,ERRCODE_NOT_FOUND,"no this route")
+	}
+}
+
+func appStatusHandler(ctx *fasthttp.RequestCtx,appid string){
+	wechatman,ok := getWechatMan(ctx)
+	if !ok{
+		return
+	}
+	status,err := wechatman.QueryAppStatus(appid,appToken(ctx))
+	if err != nil{
+		log.Println(appid+" query app status error "+err.Error())
+		replyError(ctx,0,setWechatError(ctx,err),err.Error())
+		return
+	}
+	replyJson(ctx,AppStatusResult{
+		Msg:"success",
+		ServerTime:time.Now().Unix(),
+		App:status,
+	})
+}
+
+//调用方通过X-Current-Token请求头传递持有的accessToken，或者通过updatedAfter参数传递其更新时间
+//有更新的accessToken时立即返回，否则等待到timeout秒，超时返回当前accessToken，errcode为30400
+func waitTokenHandler(ctx *fasthttp.RequestCtx,appid string){
+	current := string(ctx.Request.Header.Peek(CURRENT_TOKEN_HEADER))
+	updatedAfter,err := ctx.QueryArgs().GetUint("updatedAfter")
+	if err == fasthttp.ErrNoArgValue{
+		updatedAfter = 0
+	}else if err != nil{
+		replyError(ctx,fasthttp.StatusBadRequest,ERRCODE_BAD_REQUEST,"updatedAfter error")
+		return
+	}
+	if current == "" && updatedAfter == 0{
+		replyError(ctx,fasthttp.StatusBadRequest,ERRCODE_BAD_REQUEST,"need "+CURRENT_TOKEN_HEADER+" header or updatedAfter")
+		return
+	}
+	timeout := DEFAULT_WAIT_TIMEOUT
+	if ctx.QueryArgs().Has("timeout"){
+		if timeout,err = ctx.QueryArgs().GetUint("timeout");err != nil || timeout < 1 || timeout > MAX_WAIT_TIMEOUT{
+			replyError(ctx,fasthttp.StatusBadRequest,ERRCODE_BAD_REQUEST,"timeout need 1 to "+strconv.Itoa(MAX_WAIT_TIMEOUT))
+			return
+		}
+	}
+	wechatman,ok := getWechatMan(ctx)
+	if !ok{
+		return
+	}
+	event,err := wechatman.WaitAccessToken(appid,appToken(ctx),current,int64(updatedAfter),time.Duration(timeout)*time.Second)
+	result := WaitResult{Result:Result{Msg:"success",ServerTime:time.Now().Unix()}}
+	if event != nil && event.AccessToken != ""{
+		result.AccessToken = event.AccessToken
+		result.ExpireAt = event.ExpireAt
+		result.UpdateTime = event.UpdateTime
+	}
+	switch err{
+	case nil:
+	case wechat.ErrWaitTimeout:
+		result.Errcode = ERRCODE_NOT_MODIFIED
+		result.Msg = err.Error()
+	default:
+		log.Println(appid+" wait accesstoken error "+err.Error())
+		result.Errcode = setWechatError(ctx,err)
+		result.Msg = err.Error()
+	}
+	replyJson(ctx,result)
+}
+
+//请求body为[{"appid":"","token":""}]，每个appid单独返回errcode，整体请求成功时errcode为0
+func batchTokenHandler(ctx *fasthttp.RequestCtx){
+	queries := make([]*wechat.TokenQuery,0)
+	if err := json.Unmarshal(ctx.PostBody(),&queries);err != nil{
+		replyError(ctx,fasthttp.StatusBadRequest,ERRCODE_BAD_REQUEST,"body error "+err.Error())
+		return
+	}
+	if len(queries) == 0 || len(queries) > MAX_BATCH_SIZE{
+		replyError(ctx,fasthttp.StatusBadRequest,ERRCODE_BAD_REQUEST,"need 1 to "+strconv.Itoa(MAX_BATCH_SIZE)+" appids")
+		return
+	}
+	wechatman,ok := getWechatMan(ctx)
+	if !ok{
+		return
+	}
+	result := BatchResult{
+		Msg:"success",
+		ServerTime:time.Now().Unix(),
+		Tokens:make([]*BatchTokenResult,0,len(queries)),
+	}
+	for _,item := range wechatman.BatchQueryAccessToken(queries...){
+		token := &BatchTokenResult{
+			AppID:item.AppID,
+			AccessToken:item.AccessToken,
+			ExpireAt:item.ExpireAt,
+			Msg:"success",
+		}
+		if item.Err != nil{
+			_,token.Errcode = wechatErrorStatus(item.Err)
+			token.Msg = item.Err.Error()
+		}
+		result.Tokens = append(result.Tokens,token)
+	}
+	log.Println("batch query "+strconv.Itoa(len(queries))+" accesstoken")
+	replyJson(ctx,result)
+}
+
+func appListHandler(ctx *fasthttp.RequestCtx){
+	wechatman,ok := getWechatMan(ctx)
+	if !ok{
+		return
+	}
+	replyJson(ctx,AppListResult{
+		Msg:"success",
+		ServerTime:time.Now().Unix(),
+		Apps:wechatman.ListAppStatus(),
+	})
+}
+
+func appToken(ctx *fasthttp.RequestCtx) string{
+	return string(ctx.Request.Header.Peek(APP_TOKEN_HEADER))
+}
+
+//应用接口需要X-App-Token请求头，并校验ip白名单
+func appAuth(ctx *fasthttp.RequestCtx) bool{
+	if len(ctx.Request.Header.Peek(APP_TOKEN_HEADER)) == 0{
+		replyError(ctx,fasthttp.StatusUnauthorized,ERRCODE_UNAUTHORIZED,"need "+APP_TOKEN_HEADER+" header")
+		return false
+	}
+	return queryIpAuth(ctx)
+}
+
+func allowMethod(ctx *fasthttp.RequestCtx,method string) bool{
+	if string(ctx.Method()) != method{
+		ctx.Response.Header.Set("Allow",method)
+		replyError(ctx,fasthttp.StatusMethodNotAllowed,ERRCODE_METHOD_NOT_ALLOWED,"only "+strings.ToLower(method)+" supported")
+		return false
+	}
+	return true
+}
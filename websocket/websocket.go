@@ -0,0 +1,250 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"github.com/valyala/fasthttp"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+//只实现服务端需要的RFC 6455子集，不支持扩展和子协议
+const (
+	TEXT_MESSAGE = 1
+	BINARY_MESSAGE = 2
+	CLOSE_MESSAGE = 8
+	PING_MESSAGE = 9
+	PONG_MESSAGE = 10
+
+	CLOSE_NORMAL = 1000
+	CLOSE_PROTOCOL_ERROR = 1002
+	CLOSE_TOO_BIG = 1009
+
+	MAX_MESSAGE_SIZE = 64*1024
+	ACCEPT_GUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+var (
+	ErrBadHandshake = errors.New("websocket bad handshake")
+	ErrMessageTooBig = errors.New("websocket message too big")
+	ErrProtocol = errors.New("websocket protocol error")
+)
+
+type Conn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	writeMu   sync.Mutex
+	isClient  bool //客户端发送的帧需要掩码
+	closeSent bool
+	closed    bool
+}
+
+func newConn(c net.Conn,r io.Reader,isClient bool) *Conn{
+	return &Conn{
+		conn:c,
+		reader:bufio.NewReader(r),
+		isClient:isClient,
+	}
+}
+
+func acceptKey(key string) string{
+	h := sha1.New()
+	h.Write([]byte(key+ACCEPT_GUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func headerContains(value,token string) bool{
+	for _,item := range strings.Split(value,","){
+		if strings.EqualFold(strings.TrimSpace(item),token){
+			return true
+		}
+	}
+	return false
+}
+
+//校验握手请求并返回101，握手成功后在新的goroutine中调用handler，handler返回后连接关闭
+func Upgrade(ctx *fasthttp.RequestCtx,handler func(conn *Conn)) error{
+	key := string(ctx.Request.Header.Peek("Sec-WebSocket-Key"))
+	if !ctx.IsGet() || key == "" ||
+		!headerContains(string(ctx.Request.Header.Peek("Connection")),"upgrade") ||
+		!strings.EqualFold(string(ctx.Request.Header.Peek("Upgrade")),"websocket") ||
+		string(ctx.Request.Header.Peek("Sec-WebSocket-Version")) != "13"{
+		return ErrBadHandshake
+	}
+	ctx.SetStatusCode(fasthttp.StatusSwitchingProtocols)
+	ctx.Response.Header.Set("Upgrade","websocket")
+	ctx.Response.Header.Set("Connection","Upgrade")
+	ctx.Response.Header.Set("Sec-WebSocket-Accept",acceptKey(key))
+	ctx.Hijack(func(c net.Conn){
+		conn := newConn(c,c,false)
+		handler(conn)
+		conn.Close()
+	})
+	return nil
+}
+
+//读取一条完整的数据消息，自动回复ping，收到close时回复close并返回io.EOF
+func (conn *Conn) ReadMessage() (int,[]byte,error){
+	var opcode int
+	message := make([]byte,0)
+	for{
+		fin,op,payload,err := conn.readFrame()
+		if err != nil{
+			return 0,nil,err
+		}
+		switch op{
+		case PING_MESSAGE:
+			if err := conn.WriteMessage(PONG_MESSAGE,payload);err != nil{
+				return 0,nil,err
+			}
+			continue
+		case PONG_MESSAGE:
+			continue
+		case CLOSE_MESSAGE:
+			conn.writeClose(CLOSE_NORMAL)
+			return 0,nil,io.EOF
+		case 0:
+			if opcode == 0{
+				return 0,nil,ErrProtocol
+			}
+		case TEXT_MESSAGE,BINARY_MESSAGE:
+			if opcode != 0{
+				return 0,nil,ErrProtocol
+			}
+			opcode = op
+		default:
+			return 0,nil,ErrProtocol
+		}
+		if len(message)+len(payload) > MAX_MESSAGE_SIZE{
+			conn.writeClose(CLOSE_TOO_BIG)
+			return 0,nil,ErrMessageTooBig
+		}
+		message = append(message,payload...)
+		if fin{
+			return opcode,message,nil
+		}
+	}
+}
+
+func (conn *Conn) readFrame() (bool,int,[]byte,error){
+	header := make([]byte,2)
+	if _,err := io.ReadFull(conn.reader,header);err != nil{
+		return false,0,nil,err
+	}
+	fin := header[0]&0x80 != 0
+	opcode := int(header[0]&0x0f)
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1]&0x7f)
+	if header[0]&0x70 != 0 || masked == conn.isClient{
+		//不支持扩展，服务端只接收带掩码的帧
+		conn.writeClose(CLOSE_PROTOCOL_ERROR)
+		return false,0,nil,ErrProtocol
+	}
+	switch length{
+	case 126:
+		ext := make([]byte,2)
+		if _,err := io.ReadFull(conn.reader,ext);err != nil{
+			return false,0,nil,err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte,8)
+		if _,err := io.ReadFull(conn.reader,ext);err != nil{
+			return false,0,nil,err
+		}
+		length = binary.BigEndian.Uint64(ext)
+	}
+	if length > MAX_MESSAGE_SIZE{
+		conn.writeClose(CLOSE_TOO_BIG)
+		return false,0,nil,ErrMessageTooBig
+	}
+	var mask []byte
+	if masked{
+		mask = make([]byte,4)
+		if _,err := io.ReadFull(conn.reader,mask);err != nil{
+			return false,0,nil,err
+		}
+	}
+	payload := make([]byte,length)
+	if _,err := io.ReadFull(conn.reader,payload);err != nil{
+		return false,0,nil,err
+	}
+	for i := range payload{
+		if masked{
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin,opcode,payload,nil
+}
+
+//写入一个完整的帧，可以在多个goroutine中调用
+func (conn *Conn) WriteMessage(opcode int,data []byte) error{
+	frame := make([]byte,0,len(data)+14)
+	frame = append(frame,0x80|byte(opcode))
+	var maskBit byte
+	if conn.isClient{
+		maskBit = 0x80
+	}
+	switch{
+	case len(data) < 126:
+		frame = append(frame,maskBit|byte(len(data)))
+	case len(data) <= 0xffff:
+		frame = append(frame,maskBit|126,0,0)
+		binary.BigEndian.PutUint16(frame[2:],uint16(len(data)))
+	default:
+		frame = append(frame,maskBit|127,0,0,0,0,0,0,0,0)
+		binary.BigEndian.PutUint64(frame[2:],uint64(len(data)))
+	}
+	if conn.isClient{
+		mask := make([]byte,4)
+		rand.Read(mask)
+		frame = append(frame,mask...)
+		start := len(frame)
+		frame = append(frame,data...)
+		for i := start;i < len(frame);i++{
+			frame[i] ^= mask[(i-start)%4]
+		}
+	}else{
+		frame = append(frame,data...)
+	}
+	conn.writeMu.Lock()
+	defer conn.writeMu.Unlock()
+	if conn.closed || conn.closeSent{
+		return io.ErrClosedPipe
+	}
+	if opcode == CLOSE_MESSAGE{
+		conn.closeSent = true
+	}
+	conn.conn.SetWriteDeadline(time.Now().Add(10*time.Second))
+	_,err := conn.conn.Write(frame)
+	return err
+}
+
+func (conn *Conn) writeClose(code int){
+	payload := make([]byte,2)
+	binary.BigEndian.PutUint16(payload,uint16(code))
+	conn.WriteMessage(CLOSE_MESSAGE,payload)
+}
+
+func (conn *Conn) RemoteAddr() net.Addr{
+	return conn.conn.RemoteAddr()
+}
+
+//发送close帧后关闭连接，可以重复调用
+func (conn *Conn) Close() error{
+	conn.writeClose(CLOSE_NORMAL)
+	conn.writeMu.Lock()
+	defer conn.writeMu.Unlock()
+	if conn.closed{
+		return nil
+	}
+	conn.closed = true
+	return conn.conn.Close()
+}
@@ -0,0 +1,91 @@
+package websocket
+
+import (
+	"bufio"
+	"github.com/valyala/fasthttp"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+//测试用的客户端握手
+func dial(test *testing.T,addr string) *Conn{
+	c,err := net.Dial("tcp",addr)
+	if err != nil{
+		test.Fatal(err)
+	}
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	c.Write([]byte("GET /ws HTTP/1.1\r\nHost: "+addr+"\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: "+key+"\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	reader := bufio.NewReader(c)
+	status,err := reader.ReadString('\n')
+	if err != nil || !strings.Contains(status,"101"){
+		test.Fatal("handshake error",status,err)
+	}
+	accepted := false
+	for{
+		line,err := reader.ReadString('\n')
+		if err != nil{
+			test.Fatal(err)
+		}
+		if line == "\r\n"{
+			break
+		}
+		if strings.EqualFold(strings.TrimSpace(line),"Sec-WebSocket-Accept: "+acceptKey(key)){
+			accepted = true
+		}
+	}
+	if !accepted{
+		test.Fatal("no accept key")
+	}
+	return newConn(c,reader,true)
+}
+
+func TestEcho(test *testing.T){
+	ln,err := net.Listen("tcp","127.0.0.1:0")
+	if err != nil{
+		test.Fatal(err)
+	}
+	defer ln.Close()
+	go fasthttp.Serve(ln,func(ctx *fasthttp.RequestCtx){
+		err := Upgrade(ctx,func(conn *Conn){
+			for{
+				opcode,message,err := conn.ReadMessage()
+				if err != nil{
+					return
+				}
+				conn.WriteMessage(opcode,message)
+			}
+		})
+		if err != nil{
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		}
+	})
+	if acceptKey("dGhlIHNhbXBsZSBub25jZQ==") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="{
+		test.Error("accept key error")
+	}
+
+	conn := dial(test,ln.Addr().String())
+	long := strings.Repeat("x",70000)
+	for _,message := range []string{"hello",strings.Repeat("y",300),long[:MAX_MESSAGE_SIZE]}{
+		if err := conn.WriteMessage(TEXT_MESSAGE,[]byte(message));err != nil{
+			test.Fatal(err)
+		}
+		opcode,reply,err := conn.ReadMessage()
+		if err != nil || opcode != TEXT_MESSAGE || string(reply) != message{
+			test.Error("echo error",len(reply),err)
+		}
+	}
+	conn.WriteMessage(PING_MESSAGE,[]byte("ping"))
+	fin,opcode,payload,err := conn.readFrame()
+	if err != nil || !fin || opcode != PONG_MESSAGE || string(payload) != "ping"{
+		test.Error("pong error",opcode,err)
+	}
+	//超过最大长度时服务端关闭连接
+	conn.WriteMessage(TEXT_MESSAGE,[]byte(long))
+	if _,_,err := conn.ReadMessage();err != io.EOF{
+		test.Error("too big message should close",err)
+	}
+	conn.Close()
+}
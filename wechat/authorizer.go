@@ -0,0 +1,241 @@
+package wechat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dbldqt/wechatTokenServer/secure"
+	"github.com/tidwall/gjson"
+	"log"
+	"time"
+)
+
+const (
+	APP_TYPE_AUTHORIZER = "authorizer"  //授权给第三方平台的公众号或小程序，由第三方平台动态维护，不需要配置
+	AUTHORIZER_TOKEN_API = "/cgi-bin/component/api_authorizer_token?component_access_token=%s"
+	QUERY_AUTH_API = "/cgi-bin/component/api_query_auth?component_access_token=%s"
+	INFO_TYPE_AUTHORIZED = "authorized"
+	INFO_TYPE_UPDATE_AUTHORIZED = "updateauthorized"
+	INFO_TYPE_UNAUTHORIZED = "unauthorized"
+)
+
+func (wa *WechatApp) IsAuthorizer() bool{
+	return wa.WechatConfig.Type == APP_TYPE_AUTHORIZER
+}
+
+//授权方refresh_token保存的文件，未配置时保存在当前目录
+func (wc *WechatConfig) authorizerFile() string{
+	if wc.AuthorizerFile != ""{
+		return wc.AuthorizerFile
+	}
+	return "./component_authorizers_"+wc.AppID+".json"
+}
+
+//读取第三方平台保存的授权方列表
+func (wa *WechatApp) loadAuthorizers(){
+	wa.authorizers = make(map[string]string)
+	content,err := secure.ReadFile(wa.WechatConfig.authorizerFile())
+	if err != nil{
+		log.Println(wa.WechatConfig.AppID+" load authorizers error "+err.Error())
+		return
+	}
+	if err := json.Unmarshal(content,&wa.authorizers);err != nil{
+		log.Println(wa.WechatConfig.AppID+" parse authorizers error "+err.Error())
+	}
+}
+
+//调用方需持有wa.locker
+func (wa *WechatApp) saveAuthorizers() error{
+	content,err := json.Marshal(wa.authorizers)
+	if err != nil{
+		return err
+	}
+	return secure.WriteFile(wa.WechatConfig.authorizerFile(),content,0600)
+}
+
+func (wa *WechatApp) setAuthorizerRefreshToken(appid,refreshToken string){
+	wa.locker.Lock()
+	defer wa.locker.Unlock()
+	if refreshToken == "" || wa.authorizers[appid] == refreshToken{
+		return
+	}
+	wa.authorizers[appid] = refreshToken
+	if err := wa.saveAuthorizers();err != nil{
+		log.Println(wa.WechatConfig.AppID+" save authorizers error "+err.Error())
+	}
+}
+
+func (wa *WechatApp) newAuthorizerApp(appid string) *WechatApp{
+	app := NewWechatApp(&WechatConfig{
+		AppID:appid,
+		Token:wa.WechatConfig.Token,
+		Type:APP_TYPE_AUTHORIZER,
+		ComponentAppID:wa.WechatConfig.AppID,
+		ApiBaseURL:wa.WechatConfig.ApiBaseURL,
+	},wa.aheadTime)
+	app.component = wa
+	return app
+}
+
+//根据保存的授权方列表生成授权方应用
+func (wa *WechatApp) newAuthorizerApps() []*WechatApp{
+	apps := make([]*WechatApp,0)
+	if !wa.IsComponent(){
+		return apps
+	}
+	wa.locker.RLock()
+	for appid := range wa.authorizers{
+		apps = append(apps,wa.newAuthorizerApp(appid))
+	}
+	wa.locker.RUnlock()
+	return apps
+}
+
+//使用component_access_token和authorizer_refresh_token刷新授权方accessToken
+func (wa *WechatApp) requestAuthorizerAccessToken() ([]byte,error){
+	component := wa.component
+	if component == nil{
+		return nil,errors.New("authorizer "+wa.WechatConfig.AppID+" has no component")
+	}
+	component.locker.RLock()
+	componentToken := component.accessToken
+	apiBase := component.WechatConfig.apiBase()
+	param := map[string]string{
+		"component_appid":component.WechatConfig.AppID,
+		"authorizer_appid":wa.WechatConfig.AppID,
+		"authorizer_refresh_token":component.authorizers[wa.WechatConfig.AppID],
+	}
+	component.locker.RUnlock()
+	return postJson(apiBase+fmt.Sprintf(AUTHORIZER_TOKEN_API,componentToken),param)
+}
+
+//授权方需要第三方平台的component_access_token有效
+func (wa *WechatApp) componentReady() bool{
+	if wa.component == nil{
+		return false
+	}
+	wa.component.locker.RLock()
+	defer wa.component.locker.RUnlock()
+	return wa.component.accessToken != "" && wa.component.authorizers[wa.WechatConfig.AppID] != ""
+}
+
+func (wm *WechatMan) getComponent(appid string) *WechatApp{
+	for _,app := range wm.apps{
+		if app.WechatConfig.AppID == appid && app.IsComponent(){
+			return app
+		}
+	}
+	return nil
+}
+
+//第三方平台的所有授权方
+func (wm *WechatMan) authorizersOf(component *WechatApp) []*WechatApp{
+	wm.RLock()
+	defer wm.RUnlock()
+	apps := make([]*WechatApp,0)
+	for _,app := range wm.apps{
+		if app.IsAuthorizer() && app.component == component{
+			apps = append(apps,app)
+		}
+	}
+	return apps
+}
+
+func (wm *WechatMan) getAuthorizer(componentAppID,appid string) *WechatApp{
+	for _,app := range wm.apps{
+		if app.IsAuthorizer() && app.WechatConfig.ComponentAppID == componentAppID && app.WechatConfig.AppID == appid{
+			return app
+		}
+	}
+	return nil
+}
+
+//使用授权码换取授权方的accessToken和refresh_token，授权或者更新授权时调用
+func (wm *WechatMan) AddAuthorizer(componentAppID,authorizationCode string) error{
+	wm.RLock()
+	component := wm.getComponent(componentAppID)
+	wm.RUnlock()
+	if component == nil{
+		return ErrComponentNotFound
+	}
+
+	component.locker.RLock()
+	componentToken := component.accessToken
+	apiBase := component.WechatConfig.apiBase()
+	component.locker.RUnlock()
+	if componentToken == ""{
+		return errors.New("component_access_token of "+componentAppID+" not ready")
+	}
+	resp,err := postJson(apiBase+fmt.Sprintf(QUERY_AUTH_API,componentToken),map[string]string{
+		"component_appid":componentAppID,
+		"authorization_code":authorizationCode,
+	})
+	if err != nil{
+		return err
+	}
+	nowTime := time.Now()
+	jre := gjson.Parse(string(resp))
+	info := jre.Get("authorization_info")
+	if !info.Get("authorizer_access_token").Exists(){
+		errcode := int(jre.Get("errcode").Int())
+		errmsg := GetErrorMsg(errcode)
+		if errmsg == ERROR_UNKONWN{
+			errmsg = jre.Get("errmsg").String()
+		}
+		return errors.New(fmt.Sprintf("query auth error %d:%s",errcode,errmsg))
+	}
+	appid := info.Get("authorizer_appid").String()
+	component.setAuthorizerRefreshToken(appid,info.Get("authorizer_refresh_token").String())
+
+	wm.Lock()
+	app := wm.getAuthorizer(componentAppID,appid)
+	if app == nil{
+		app = component.newAuthorizerApp(appid)
+		wm.apps = append(wm.apps,app)
+	}
+	wm.Unlock()
+
+	app.locker.Lock()
+	app.accessToken = info.Get("authorizer_access_token").String()
+	app.duration = time.Second*time.Duration(int(info.Get("expires_in").Int())-app.aheadTime)
+	app.updateTime = nowTime
+	app.needUpdate = false
+	app.deleted = false
+	//新授权或者更新授权同样通知订阅者
+	event := app.tokenEvent(EVENT_SOURCE_REFRESH)
+	app.locker.Unlock()
+	eventBus.Publish(event)
+	log.Println(componentAppID+" add authorizer "+appid)
+	wm.reschedule(app)
+	wm.saveTokens(app)
+	return nil
+}
+
+//授权方取消授权后不再维护其accessToken
+func (wm *WechatMan) RemoveAuthorizer(componentAppID,appid string) error{
+	wm.Lock()
+	component := wm.getComponent(componentAppID)
+	if component == nil{
+		wm.Unlock()
+		return ErrComponentNotFound
+	}
+	newAPPs := make([]*WechatApp,0)
+	removed := make([]*WechatApp,0)
+	for _,app := range wm.apps{
+		if !(app.IsAuthorizer() && app.WechatConfig.ComponentAppID == componentAppID && app.WechatConfig.AppID == appid){
+			newAPPs = append(newAPPs,app)
+		}else{
+			wm.detachApp(app)
+			removed = append(removed,app)
+		}
+	}
+	wm.apps = newAPPs
+	wm.Unlock()
+	wm.deleteTokens(removed...)
+
+	component.locker.Lock()
+	defer component.locker.Unlock()
+	delete(component.authorizers,appid)
+	log.Println(componentAppID+" remove authorizer "+appid)
+	return component.saveAuthorizers()
+}
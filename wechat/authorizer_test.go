@@ -0,0 +1,90 @@
+package wechat
+
+import (
+	"github.com/dbldqt/wechatTokenServer/store"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//新授权的授权方推送token_rotated事件
+func TestAddAuthorizerEvent(test *testing.T){
+	dir,err := ioutil.TempDir("","wechatman")
+	if err != nil{
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,r *http.Request){
+		w.Write([]byte(`{"authorization_info":{"authorizer_appid":"wx_new_authorizer","authorizer_access_token":"authorizer_token","expires_in":7200,"authorizer_refresh_token":"refresh_token"}}`))
+	}))
+	defer server.Close()
+
+	component := NewWechatApp(&WechatConfig{AppID:"wx_event_component",AppSecret:"secret",Token:"token",Type:APP_TYPE_COMPONENT,ApiBaseURL:server.URL,
+		VerifyTicketFile:filepath.Join(dir,"ticket"),AuthorizerFile:filepath.Join(dir,"authorizers")},600)
+	component.accessToken = "component_token"
+	wm := &WechatMan{apps:[]*WechatApp{component},store:store.NewMemoryStore()}
+	sub := eventBus.Subscribe("wx_event_component:wx_new_authorizer")
+	defer sub.Close()
+	if err := wm.AddAuthorizer("wx_event_component","auth_code");err != nil{
+		test.Fatal(err)
+	}
+	event := waitEvent(test,sub,EVENT_TOKEN_ROTATED,time.Second)
+	if event.AccessToken != "authorizer_token" || event.ComponentAppID != "wx_event_component"{
+		test.Error("add authorizer event error",event)
+	}
+}
+
+//取消授权时正在刷新的授权方不会把refresh_token写回授权方列表，也不推送token_rotated事件
+func TestRemoveAuthorizerDuringRefresh(test *testing.T){
+	dir,err := ioutil.TempDir("","wechatman")
+	if err != nil{
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	received := make(chan int)
+	release := make(chan int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,r *http.Request){
+		received<-1
+		<-release
+		w.Write([]byte(`{"authorizer_access_token":"revoked_token","expires_in":7200,"authorizer_refresh_token":"revoked_refresh_token"}`))
+	}))
+	defer server.Close()
+
+	wm := newTestWechatMan(600,60,&WechatConfig{AppID:"wx_remove_component",AppSecret:"secret",Token:"token",Type:APP_TYPE_COMPONENT,ApiBaseURL:server.URL,
+		VerifyTicketFile:filepath.Join(dir,"ticket"),AuthorizerFile:filepath.Join(dir,"authorizers")})
+	component := wm.apps[0]
+	component.accessToken = "component_token"
+	component.authorizers["wx_remove_authorizer"] = "refresh_token"
+	authorizer := component.newAuthorizerApp("wx_remove_authorizer")
+	wm.apps = append(wm.apps,authorizer)
+	sub := eventBus.Subscribe("wx_remove_component:wx_remove_authorizer")
+	defer sub.Close()
+
+	done := make(chan int)
+	go func(){
+		wm.ForceRefreshAccessToken("wx_remove_authorizer")
+		close(done)
+	}()
+	<-received
+	if err := wm.RemoveAuthorizer("wx_remove_component","wx_remove_authorizer");err != nil{
+		test.Fatal(err)
+	}
+	close(release)
+	<-done
+	waitEvent(test,sub,EVENT_APP_DELETED,time.Second)
+	component.locker.RLock()
+	refreshToken,ok := component.authorizers["wx_remove_authorizer"]
+	component.locker.RUnlock()
+	if ok{
+		test.Error("removed authorizer should not be written back",refreshToken)
+	}
+	select{
+	case event := <-sub.C:
+		test.Error("removed authorizer should not publish event",event)
+	default:
+	}
+}
@@ -0,0 +1,163 @@
+package wechat
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"github.com/dbldqt/wechatTokenServer/secure"
+	"github.com/valyala/fasthttp"
+	"log"
+	"strings"
+)
+
+const (
+	APP_TYPE_COMPONENT = "component"  //开放平台第三方平台
+	COMPONENT_TOKEN_API = "/cgi-bin/component/api_component_token"
+	INFO_TYPE_VERIFY_TICKET = "component_verify_ticket"
+)
+
+//授权事件接收url收到的推送，外层只有AppId和加密内容
+type componentEncryptNotify struct {
+	AppId   string `xml:"AppId"`
+	Encrypt string `xml:"Encrypt"`
+}
+
+//解密后的授权事件推送
+type ComponentNotify struct {
+	AppId                 string `xml:"AppId"`
+	CreateTime            int64  `xml:"CreateTime"`
+	InfoType              string `xml:"InfoType"`
+	ComponentVerifyTicket string `xml:"ComponentVerifyTicket"`
+	AuthorizerAppid       string `xml:"AuthorizerAppid"`
+	AuthorizationCode     string `xml:"AuthorizationCode"`
+}
+
+func (wa *WechatApp) IsComponent() bool{
+	return wa.WechatConfig.Type == APP_TYPE_COMPONENT
+}
+
+//component_verify_ticket保存的文件，未配置时保存在当前目录
+func (wc *WechatConfig) verifyTicketFile() string{
+	if wc.VerifyTicketFile != ""{
+		return wc.VerifyTicketFile
+	}
+	return "./component_verify_ticket_"+wc.AppID
+}
+
+//读取上次保存的component_verify_ticket，避免重启后等待微信下次推送
+func (wa *WechatApp) loadVerifyTicket(){
+	content,err := secure.ReadFile(wa.WechatConfig.verifyTicketFile())
+	if err != nil{
+		log.Println(wa.WechatConfig.AppID+" load component_verify_ticket error "+err.Error())
+		return
+	}
+	wa.verifyTicket = strings.TrimSpace(string(content))
+}
+
+func (wa *WechatApp) GetVerifyTicket() string{
+	return wa.verifyTicket
+}
+
+//第三方平台获取component_access_token
+func (wa *WechatApp) requestComponentAccessToken() ([]byte,error){
+	wa.locker.RLock()
+	param := map[string]string{
+		"component_appid":wa.WechatConfig.AppID,
+		"component_appsecret":wa.WechatConfig.AppSecret,
+		"component_verify_ticket":wa.verifyTicket,
+	}
+	wa.locker.RUnlock()
+	return postJson(wa.WechatConfig.apiBase()+COMPONENT_TOKEN_API,param)
+}
+
+func postJson(url string,param interface{}) ([]byte,error){
+	body,err := json.Marshal(param)
+	if err != nil{
+		return nil,err
+	}
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI(url)
+	req.Header.SetMethod("POST")
+	req.Header.SetContentType("application/json")
+	req.SetBody(body)
+	if err := fasthttp.Do(req,resp);err != nil{
+		return nil,err
+	}
+	return append([]byte(nil),resp.Body()...),nil
+}
+
+//保存微信推送的component_verify_ticket，同时写入文件
+func (wm *WechatMan) SetComponentVerifyTicket(appid,ticket string) error{
+	wm.RLock()
+	defer wm.RUnlock()
+	for _,app := range wm.apps{
+		if app.WechatConfig.AppID != appid || !app.IsComponent(){
+			continue
+		}
+		app.locker.Lock()
+		app.verifyTicket = ticket
+		app.locker.Unlock()
+		//第一次收到component_verify_ticket时立即获取component_access_token
+		wm.reschedule(app)
+		return secure.WriteFile(app.WechatConfig.verifyTicketFile(),[]byte(ticket),0600)
+	}
+	return ErrComponentNotFound
+}
+
+//处理授权事件接收url收到的推送，校验签名并解密
+func (wm *WechatMan) HandleComponentNotify(timestamp,nonce,msgSignature string,body []byte) error{
+	encryptNotify := componentEncryptNotify{}
+	if err := xml.Unmarshal(body,&encryptNotify);err != nil{
+		return err
+	}
+
+	var msgToken,aesKey string
+	wm.RLock()
+	for _,app := range wm.apps{
+		if app.WechatConfig.AppID == encryptNotify.AppId && app.IsComponent(){
+			app.locker.RLock()
+			msgToken = app.WechatConfig.MsgToken
+			aesKey = app.WechatConfig.EncodingAESKey
+			app.locker.RUnlock()
+			break
+		}
+	}
+	wm.RUnlock()
+	if aesKey == ""{
+		return ErrComponentNotFound
+	}
+
+	if subtle.ConstantTimeCompare([]byte(MsgSignature(msgToken,timestamp,nonce,encryptNotify.Encrypt)),[]byte(msgSignature)) != 1{
+		return ErrMsgSignature
+	}
+	msg,appid,err := DecryptMsg(aesKey,encryptNotify.Encrypt)
+	if err != nil{
+		return err
+	}
+	if appid != encryptNotify.AppId{
+		return errors.New("appid in encrypt msg not match")
+	}
+
+	notify := ComponentNotify{}
+	if err := xml.Unmarshal(msg,&notify);err != nil{
+		return err
+	}
+	switch notify.InfoType{
+	case INFO_TYPE_VERIFY_TICKET:
+		log.Println(appid+" receive component_verify_ticket")
+		return wm.SetComponentVerifyTicket(appid,notify.ComponentVerifyTicket)
+	case INFO_TYPE_AUTHORIZED,INFO_TYPE_UPDATE_AUTHORIZED:
+		log.Println(appid+" receive "+notify.InfoType+" from "+notify.AuthorizerAppid)
+		return wm.AddAuthorizer(appid,notify.AuthorizationCode)
+	case INFO_TYPE_UNAUTHORIZED:
+		log.Println(appid+" receive unauthorized from "+notify.AuthorizerAppid)
+		return wm.RemoveAuthorizer(appid,notify.AuthorizerAppid)
+	default:
+		log.Println(appid+" ignore component notify "+notify.InfoType)
+	}
+	return nil
+}
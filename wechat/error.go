@@ -1,8 +1,20 @@
 package wechat
 
+import "errors"
+
 const (
 	ERROR_UNKONWN = "unknown error code"
 )
+
+var (
+	ErrAppNotFound = errors.New("no app for this appid and token")
+	ErrAppDeleted = errors.New("this app is deleted,can't ensure the accesstoken is valid")
+	ErrTokenNotReady = errors.New("no accesstoken for this appid and token")
+	ErrTicketNotReady = errors.New("no ticket for this appid and token")
+	ErrComponentNotFound = errors.New("no component for this appid")
+	ErrMsgSignature = errors.New("msg signature error")
+	ErrWaitTimeout = errors.New("no newer accesstoken before timeout")
+)
 func GetErrorMsg(code int) string{
 	if msg,ok := wechatError[code];ok {
 		return msg
@@ -11,6 +23,31 @@ func GetErrorMsg(code int) string{
 	}
 }
 
+//企业微信使用单独的错误码
+func GetWecomErrorMsg(code int) string{
+	if msg,ok := wecomError[code];ok {
+		return msg
+	}else{
+		return ERROR_UNKONWN
+	}
+}
+
+//根据应用的服务提供方获取错误信息
+func GetProviderErrorMsg(provider string,code int) string{
+	if provider == PROVIDER_WECOM{
+		return GetWecomErrorMsg(code)
+	}
+	return GetErrorMsg(code)
+}
+
+//配置错误导致的errcode不能重试，需要修改配置后重载或者强制刷新，-1系统繁忙、45009调用次数超限以及网络错误等都重试
+func IsRetryableErrcode(provider string,code int) bool{
+	if provider == PROVIDER_WECOM{
+		return !wecomPermanentError[code]
+	}
+	return !wechatPermanentError[code]
+}
+
 var wechatError = map[int]string{
 	-1	 :"系统繁忙，此时请开发者稍候再试",
 	0	 :"请求成功",
@@ -172,4 +209,44 @@ var wechatError = map[int]string{
 	9001034	:"设备备注信息过长",
 	9001035	:"设备申请参数不合法",
 	9001036	:"查询起始值 begin 不合法",
-}
\ No newline at end of file
+}
+
+var wecomError = map[int]string{
+	-1	 :"系统繁忙，服务器暂不可用，建议稍候重试",
+	0	 :"请求成功",
+	40001:"不合法的secret参数，请确认secret是否正确，是否是应用对应的secret",
+	40003:"无效的UserID",
+	40013:"不合法的CorpID，请确认CorpID是否正确，避免异常字符，注意大小写",
+	40014:"不合法的access_token",
+	40056:"不合法的agentid",
+	40082:"不合法的suitetoken",
+	40091:"secret不合法，可能是secret被重置或者应用已删除",
+	41001:"缺少access_token参数",
+	41002:"缺少corpid参数",
+	41004:"缺少secret参数",
+	42001:"access_token已过期",
+	42009:"suite_token已过期",
+	45009:"接口调用超过限制",
+	45033:"接口并发调用超过限制",
+	48002:"API接口无权限调用",
+	60011:"指定的成员/部门/标签参数无权限",
+	60020:"访问ip不在白名单之中",
+	301002:"无权限操作指定的应用",
+}
+
+//40013 appid无效，40125 appsecret无效，40164 调用ip不在白名单
+var wechatPermanentError = map[int]bool{
+	40013:true,
+	40125:true,
+	40164:true,
+}
+
+//40001 secret无效，40013 corpid无效，40091 secret被重置，41002 缺少corpid，41004 缺少secret，60020 调用ip不在白名单
+var wecomPermanentError = map[int]bool{
+	40001:true,
+	40013:true,
+	40091:true,
+	41002:true,
+	41004:true,
+	60020:true,
+}
@@ -15,3 +15,17 @@ func TestGetErrorCode(test *testing.T){
 		test.Error("code -1 error")
 	}
 }
+
+func TestGetWecomErrorMsg(test *testing.T){
+	if GetWecomErrorMsg(40013) != "不合法的CorpID，请确认CorpID是否正确，避免异常字符，注意大小写"{
+		test.Error("wecom code 40013 error")
+	}
+
+	if GetProviderErrorMsg(PROVIDER_WECOM,40001) == GetProviderErrorMsg(PROVIDER_WECHAT,40001){
+		test.Error("provider error table error")
+	}
+
+	if GetWecomErrorMsg(9001001) != ERROR_UNKONWN{
+		test.Error("wecom unknown code error")
+	}
+}
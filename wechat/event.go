@@ -0,0 +1,234 @@
+package wechat
+
+import (
+	"github.com/dbldqt/wechatTokenServer/store"
+	"sync"
+	"time"
+)
+
+const (
+	EVENT_TOKEN_ROTATED = "token_rotated"
+	EVENT_APP_DELETED = "app_deleted"
+	EVENT_REFRESH_FAILED = "refresh_failed"
+	EVENT_SOURCE_REFRESH = "refresh" //当前节点请求微信接口刷新
+	EVENT_HISTORY_SIZE = 1024 //保留最近的事件，用于断线重连后补发
+	EVENT_BUFFER_SIZE = 64    //每个订阅者的缓冲，写满后关闭订阅，由订阅者重连补发
+)
+
+//accessToken变化等事件，ID单调递增，用于断线重连
+type Event struct {
+	ID             uint64 `json:"id"`
+	Type           string `json:"type"`
+	AppID          string `json:"appid"`
+	ComponentAppID string `json:"componentAppid,omitempty"`
+	AccessToken    string `json:"accessToken,omitempty"`
+	UpdateTime     int64  `json:"updateTime,omitempty"`
+	ExpireAt       int64  `json:"expireAt,omitempty"`
+	Errcode        int    `json:"errcode,omitempty"`
+	Error          string `json:"error,omitempty"`
+	Source         string `json:"source,omitempty"` //accessToken来源，refresh或者从快照、存储、leader同步
+	Time           int64  `json:"time"`
+}
+
+//事件对应应用的key，与store.TokenKey相同，不同第三方平台下的同一个授权方appid是不同的应用
+func (event *Event) Key() string{
+	return store.TokenKey(event.ComponentAppID,event.AppID)
+}
+
+//事件订阅，all为true时接收所有应用的事件，否则只接收keys中的应用
+//key为store.TokenKey(componentAppID,appid)，需要使用通过token校验的应用生成，普通应用的key就是appid
+type Subscription struct {
+	C      chan *Event
+	all    bool
+	keys   map[string]bool
+	bus    *EventBus
+	closed bool
+}
+
+//调用方需要持有bus的锁
+func (sub *Subscription) match(event *Event) bool{
+	return sub.all || sub.keys[event.Key()]
+}
+
+//增加订阅的应用
+func (sub *Subscription) Add(keys ...string){
+	sub.bus.Lock()
+	for _,key := range keys{
+		sub.keys[key] = true
+	}
+	sub.bus.Unlock()
+}
+
+func (sub *Subscription) Remove(keys ...string){
+	sub.bus.Lock()
+	for _,key := range keys{
+		delete(sub.keys,key)
+	}
+	sub.bus.Unlock()
+}
+
+//取消订阅appid对应的所有应用，包括不同第三方平台下的同一个授权方
+func (sub *Subscription) RemoveAppID(appid string){
+	sub.bus.Lock()
+	for key := range sub.keys{
+		if _,keyAppID := store.ParseTokenKey(key);keyAppID == appid{
+			delete(sub.keys,key)
+		}
+	}
+	sub.bus.Unlock()
+}
+
+//当前订阅的应用数量
+func (sub *Subscription) Len() int{
+	sub.bus.Lock()
+	defer sub.bus.Unlock()
+	return len(sub.keys)
+}
+
+func (sub *Subscription) Close(){
+	sub.bus.unsubscribe(sub)
+}
+
+//进程内的事件总线，发布时不阻塞，订阅者处理不过来时关闭其订阅
+type EventBus struct {
+	sync.Mutex
+	lastID      uint64
+	history     []*Event
+	subscribers map[*Subscription]bool
+}
+
+func NewEventBus() *EventBus{
+	return &EventBus{
+		history:make([]*Event,0,EVENT_HISTORY_SIZE),
+		subscribers:make(map[*Subscription]bool),
+	}
+}
+
+func (eb *EventBus) Publish(event *Event){
+	eb.Lock()
+	defer eb.Unlock()
+	eb.lastID++
+	event.ID = eb.lastID
+	if event.Time == 0{
+		event.Time = time.Now().Unix()
+	}
+	if len(eb.history) >= EVENT_HISTORY_SIZE{
+		eb.history = append(eb.history[:0],eb.history[1:]...)
+	}
+	eb.history = append(eb.history,event)
+	for sub := range eb.subscribers{
+		if !sub.match(event){
+			continue
+		}
+		select{
+		case sub.C<-event:
+		default:
+			eb.close(sub)
+		}
+	}
+}
+
+//订阅指定应用的事件，keys为空时可以之后通过Add增加
+func (eb *EventBus) Subscribe(keys ...string) *Subscription{
+	sub,_,_ := eb.SubscribeSince(0,keys...)
+	return sub
+}
+
+//订阅所有应用的事件
+func (eb *EventBus) SubscribeAll() *Subscription{
+	sub := eb.Subscribe()
+	eb.Lock()
+	sub.all = true
+	eb.Unlock()
+	return sub
+}
+
+//订阅并返回lastID之后的历史事件，历史事件已经被清理时complete为false，调用方需要重新获取当前状态
+func (eb *EventBus) SubscribeSince(lastID uint64,keys ...string) (*Subscription,[]*Event,bool){
+	sub := &Subscription{
+		C:make(chan *Event,EVENT_BUFFER_SIZE),
+		keys:make(map[string]bool),
+		bus:eb,
+	}
+	for _,key := range keys{
+		sub.keys[key] = true
+	}
+	eb.Lock()
+	defer eb.Unlock()
+	eb.subscribers[sub] = true
+	if lastID == 0{
+		return sub,nil,true
+	}
+	//lastID大于当前ID说明来自重启前的进程或其他节点，同样需要重新获取当前状态
+	complete := lastID == eb.lastID || lastID < eb.lastID && len(eb.history) > 0 && eb.history[0].ID <= lastID+1
+	backlog := make([]*Event,0)
+	for _,event := range eb.history{
+		if event.ID > lastID && sub.match(event){
+			backlog = append(backlog,event)
+		}
+	}
+	return sub,backlog,complete
+}
+
+func (eb *EventBus) unsubscribe(sub *Subscription){
+	eb.Lock()
+	eb.close(sub)
+	eb.Unlock()
+}
+
+//调用方需要持有eb的锁
+func (eb *EventBus) close(sub *Subscription){
+	if sub.closed{
+		return
+	}
+	sub.closed = true
+	delete(eb.subscribers,sub)
+	close(sub.C)
+}
+
+var eventBus = NewEventBus()
+
+//返回进程内唯一的事件总线
+func GetEventBus() *EventBus{
+	return eventBus
+}
+
+//调用方需要持有wa.locker
+func (wa *WechatApp) tokenEvent(source string) *Event{
+	return &Event{
+		Type:EVENT_TOKEN_ROTATED,
+		AppID:wa.WechatConfig.AppID,
+		ComponentAppID:wa.WechatConfig.ComponentAppID,
+		AccessToken:wa.accessToken,
+		UpdateTime:wa.updateTime.Unix(),
+		ExpireAt:wa.updateTime.Add(wa.duration).Unix(),
+		Source:source,
+	}
+}
+
+func (wa *WechatApp) publishRefreshFailed(errcode int,errmsg string){
+	eventBus.Publish(&Event{
+		Type:EVENT_REFRESH_FAILED,
+		AppID:wa.WechatConfig.AppID,
+		ComponentAppID:wa.WechatConfig.ComponentAppID,
+		Errcode:errcode,
+		Error:errmsg,
+		Source:EVENT_SOURCE_REFRESH,
+	})
+}
+
+//查询当前accessToken对应的事件，用于订阅者断线过久时同步当前状态
+func (wm *WechatMan) CurrentTokenEvent(appid,token string) (*Event,error){
+	wm.RLock()
+	defer wm.RUnlock()
+	for _,app := range wm.apps{
+		app.locker.RLock()
+		if app.WechatConfig.AppID == appid && app.WechatConfig.Token == token{
+			event := app.tokenEvent("")
+			app.locker.RUnlock()
+			return event,nil
+		}
+		app.locker.RUnlock()
+	}
+	return nil,ErrAppNotFound
+}
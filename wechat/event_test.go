@@ -0,0 +1,84 @@
+package wechat
+
+import (
+	"testing"
+)
+
+func TestEventBus(test *testing.T){
+	eb := NewEventBus()
+	sub := eb.Subscribe("wx_a")
+	all := eb.SubscribeAll()
+	eb.Publish(&Event{Type:EVENT_TOKEN_ROTATED,AppID:"wx_a",AccessToken:"a1"})
+	eb.Publish(&Event{Type:EVENT_TOKEN_ROTATED,AppID:"wx_b",AccessToken:"b1"})
+	if event := <-sub.C;event.ID != 1 || event.AccessToken != "a1"{
+		test.Error("subscriber should receive wx_a event",event)
+	}
+	if len(sub.C) != 0 || len(all.C) != 2{
+		test.Error("subscriber filter error")
+	}
+
+	resumed,backlog,complete := eb.SubscribeSince(1,"wx_a","wx_b")
+	if !complete || len(backlog) != 1 || backlog[0].AccessToken != "b1"{
+		test.Error("resume backlog error",backlog)
+	}
+	//来自其他进程的id无法补发
+	if _,_,complete := eb.SubscribeSince(100,"wx_a");complete{
+		test.Error("unknown id should not complete")
+	}
+
+	sub.Close()
+	resumed.Close()
+	if _,ok := <-sub.C;ok{
+		test.Error("closed subscription channel should be closed")
+	}
+	//处理不过来的订阅者被关闭
+	for i := 0;i <= EVENT_BUFFER_SIZE;i++{
+		eb.Publish(&Event{Type:EVENT_TOKEN_ROTATED,AppID:"wx_a"})
+	}
+	count := 0
+	for range all.C{
+		count++
+	}
+	if count != EVENT_BUFFER_SIZE{
+		test.Error("slow subscriber should be closed after buffer full",count)
+	}
+}
+
+func TestEventBusHistory(test *testing.T){
+	eb := NewEventBus()
+	for i := 0;i < EVENT_HISTORY_SIZE+10;i++{
+		eb.Publish(&Event{Type:EVENT_TOKEN_ROTATED,AppID:"wx_a"})
+	}
+	if _,_,complete := eb.SubscribeSince(5,"wx_a");complete{
+		test.Error("dropped history should not complete")
+	}
+	if _,backlog,complete := eb.SubscribeSince(EVENT_HISTORY_SIZE+5,"wx_a");!complete || len(backlog) != 5{
+		test.Error("recent history should complete",len(backlog))
+	}
+}
+
+func TestAppDeletedEvent(test *testing.T){
+	wm := &WechatMan{apps:[]*WechatApp{NewWechatApp(&WechatConfig{AppID:"wx_deleted",Token:"token"},600)}}
+	sub := GetEventBus().Subscribe("wx_deleted")
+	defer sub.Close()
+	wm.DelWechatAppByAppID("wx_deleted")
+	if event := <-sub.C;event.Type != EVENT_APP_DELETED || event.AppID != "wx_deleted"{
+		test.Error("delete app should publish event",event)
+	}
+}
+
+//不同第三方平台下同一个授权方appid的事件互不推送
+func TestSubscribeAuthorizerKey(test *testing.T){
+	eb := NewEventBus()
+	sub := eb.Subscribe("wx_component_a:wx_shared")
+	eb.Publish(&Event{Type:EVENT_TOKEN_ROTATED,AppID:"wx_shared",ComponentAppID:"wx_component_b",AccessToken:"b1"})
+	eb.Publish(&Event{Type:EVENT_TOKEN_ROTATED,AppID:"wx_shared",ComponentAppID:"wx_component_a",AccessToken:"a1"})
+	if event := <-sub.C;event.AccessToken != "a1" || len(sub.C) != 0{
+		test.Error("subscriber should only receive its component's event",event)
+	}
+	sub.Add("wx_component_b:wx_shared","wx_other")
+	sub.RemoveAppID("wx_shared")
+	if sub.Len() != 1{
+		test.Error("remove appid should remove all components' keys",sub.Len())
+	}
+}
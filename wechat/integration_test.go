@@ -0,0 +1,203 @@
+package wechat
+
+import (
+	"github.com/dbldqt/wechatTokenServer/fakewechat"
+	"github.com/dbldqt/wechatTokenServer/store"
+	"github.com/tidwall/gjson"
+	"github.com/valyala/fasthttp"
+	"testing"
+	"time"
+)
+
+//与BuildWechatMan相同，但不使用单例，也不启动NotifyUrl通知
+func newTestWechatMan(aheadTime,loopTime int,wxconfs ...*WechatConfig) *WechatMan{
+	wm := &WechatMan{
+		apps:[]*WechatApp{},
+		loopStopChan:make(chan int),
+		aheadTime:aheadTime,
+		loopTime:loopTime,
+		sched:newScheduler(),
+		store:store.NewMemoryStore(),
+	}
+	for _,conf := range wxconfs{
+		wm.apps = append(wm.apps,NewWechatApp(conf,aheadTime))
+	}
+	wm.retry = DefaultRetryPolicy
+	return wm
+}
+
+func newFakeWechat(test *testing.T,appids ...string) *fakewechat.Server{
+	fake,err := fakewechat.NewServer()
+	if err != nil{
+		test.Fatal(err)
+	}
+	for _,appid := range appids{
+		fake.AddApp(appid,"secret")
+	}
+	return fake
+}
+
+//等待指定类型的事件，忽略其他事件
+func waitEvent(test *testing.T,sub *Subscription,eventType string,timeout time.Duration) *Event{
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for{
+		select{
+		case event := <-sub.C:
+			if event.Type == eventType{
+				return event
+			}
+		case <-timer.C:
+			test.Fatal("wait event "+eventType+" timeout")
+			return nil
+		}
+	}
+}
+
+func TestRefreshLoop(test *testing.T){
+	fake := newFakeWechat(test,"wx_loop")
+	defer fake.Close()
+	fake.SetExpiresIn(2)
+	wm := newTestWechatMan(1,1,&WechatConfig{AppID:"wx_loop",AppSecret:"secret",Token:"token",JsapiTicket:true,ApiBaseURL:fake.URL()})
+	wm.retry = RetryPolicy{MaxAttempts:3,BaseDelay:100*time.Millisecond,MaxDelay:200*time.Millisecond}
+	sub := eventBus.Subscribe("wx_loop")
+	defer sub.Close()
+	wm.Run()
+	defer wm.Stop()
+
+	first := waitEvent(test,sub,EVENT_TOKEN_ROTATED,3*time.Second)
+	if first.AccessToken != fake.AccessToken("wx_loop") || first.ExpireAt-first.UpdateTime != 1{
+		test.Error("first token error",first)
+	}
+	deadline := time.Now().Add(2*time.Second)
+	for{
+		if ticket,_,err := wm.QueryTicket("wx_loop","token",TICKET_TYPE_JSAPI);err == nil && ticket != ""{
+			break
+		}
+		if time.Now().After(deadline){
+			test.Fatal("jsapi ticket not ready")
+		}
+		time.Sleep(50*time.Millisecond)
+	}
+
+	//失败时保留旧的accessToken，按照重试策略重试
+	fake.FailNext(-1,45009)
+	for _,errcode := range []int{-1,45009}{
+		failed := waitEvent(test,sub,EVENT_REFRESH_FAILED,3*time.Second)
+		if failed.Errcode != errcode{
+			test.Errorf("refresh failed errcode %d,want %d",failed.Errcode,errcode)
+		}
+	}
+	if accessToken,_,err := wm.QueryAccessToken("wx_loop","token");err != nil || accessToken != first.AccessToken{
+		test.Error("failed refresh should keep old accesstoken",accessToken,err)
+	}
+	second := waitEvent(test,sub,EVENT_TOKEN_ROTATED,3*time.Second)
+	if second.AccessToken == first.AccessToken || second.AccessToken != fake.AccessToken("wx_loop"){
+		test.Error("second token error",second)
+	}
+}
+
+func TestRebuildWithFakeWechat(test *testing.T){
+	fake := newFakeWechat(test,"wx_rebuild_a","wx_rebuild_b")
+	defer fake.Close()
+	confA := &WechatConfig{AppID:"wx_rebuild_a",AppSecret:"secret",Token:"token",ApiBaseURL:fake.URL()}
+	confB := &WechatConfig{AppID:"wx_rebuild_b",AppSecret:"secret",Token:"token",ApiBaseURL:fake.URL()}
+	wm := newTestWechatMan(600,1,confA)
+	sub := eventBus.Subscribe("wx_rebuild_a","wx_rebuild_b")
+	defer sub.Close()
+	wm.Run()
+	defer wm.Stop()
+	if event := waitEvent(test,sub,EVENT_TOKEN_ROTATED,3*time.Second);event.AppID != "wx_rebuild_a"{
+		test.Fatal("first event should be wx_rebuild_a",event)
+	}
+
+	//新增应用，已有应用的accessToken仍然有效，不重新请求
+	if err := wm.Rebuild(600,1,&WechatConfig{AppID:"wx_rebuild_a",AppSecret:"secret",Token:"token",ApiBaseURL:fake.URL()},confB);err != nil{
+		test.Fatal(err)
+	}
+	if event := waitEvent(test,sub,EVENT_TOKEN_ROTATED,3*time.Second);event.AppID != "wx_rebuild_b"{
+		test.Fatal("added app should refresh",event)
+	}
+	if fake.Requests("wx_rebuild_a") != 1{
+		test.Error("unchanged app should not refresh",fake.Requests("wx_rebuild_a"))
+	}
+
+	//删除应用
+	if err := wm.Rebuild(600,1,confB);err != nil{
+		test.Fatal(err)
+	}
+	if event := waitEvent(test,sub,EVENT_APP_DELETED,time.Second);event.AppID != "wx_rebuild_a"{
+		test.Error("app deleted event error",event)
+	}
+	if _,_,err := wm.QueryAccessToken("wx_rebuild_a","token");err != ErrAppDeleted{
+		test.Error("deleted app should return ErrAppDeleted",err)
+	}
+
+	//修改接口地址后使用新地址重新获取accessToken
+	other := newFakeWechat(test,"wx_rebuild_b")
+	defer other.Close()
+	if err := wm.Rebuild(600,1,&WechatConfig{AppID:"wx_rebuild_b",AppSecret:"secret",Token:"token",ApiBaseURL:other.URL()});err != nil{
+		test.Fatal(err)
+	}
+	event := waitEvent(test,sub,EVENT_TOKEN_ROTATED,3*time.Second)
+	if event.AppID != "wx_rebuild_b" || event.AccessToken != other.AccessToken("wx_rebuild_b") || fake.Requests("wx_rebuild_b") != 1{
+		test.Error("changed api base url should refresh from new url",event)
+	}
+}
+
+//stable_token定时刷新使用普通模式，在轮换窗口内获取新的accessToken，其他调用方持有的accessToken不会失效
+//返回同一个accessToken时不推送事件，只有强制刷新使用force_refresh=true
+func TestStableTokenUnchanged(test *testing.T){
+	fake := newFakeWechat(test,"wx_stable")
+	defer fake.Close()
+	fake.SetExpiresIn(3)
+	fake.SetRotateWindow(2)
+	wm := newTestWechatMan(1,60,&WechatConfig{AppID:"wx_stable",AppSecret:"secret",Token:"token",StableToken:true,ApiBaseURL:fake.URL()})
+	sub := eventBus.Subscribe("wx_stable")
+	defer sub.Close()
+	wm.Run()
+	defer wm.Stop()
+
+	first := waitEvent(test,sub,EVENT_TOKEN_ROTATED,time.Second).AccessToken
+	app := wm.apps[0]
+	_,ok := app.refresh(false)
+	app.locker.RLock()
+	accessToken,duration := app.accessToken,app.duration
+	app.locker.RUnlock()
+	if !ok || accessToken != first || duration <= 0{
+		test.Fatal("stable token should be reused",accessToken,duration)
+	}
+	select{
+	case event := <-sub.C:
+		test.Error("unchanged accesstoken should not publish event",event)
+	default:
+	}
+
+	//到期时普通模式刷新，旧的accessToken仍然有效
+	second := waitEvent(test,sub,EVENT_TOKEN_ROTATED,3*time.Second).AccessToken
+	if second == first || second != fake.AccessToken("wx_stable") || fake.Requests("wx_stable") != 3{
+		test.Error("stable token should rotate in window",fake.Requests("wx_stable"))
+	}
+	if errcode := fakeApiErrcode(test,fake.URL(),first);errcode != 0{
+		test.Error("loop refresh should not invalidate old token",errcode)
+	}
+
+	//强制刷新使旧的accessToken失效
+	wm.ForceRefreshAccessToken("wx_stable")
+	if errcode := fakeApiErrcode(test,fake.URL(),second);errcode != 40001{
+		test.Error("force refresh should invalidate old token",errcode)
+	}
+
+	if ahead := NewWechatApp(&WechatConfig{AppID:"wx_stable_ahead",StableToken:true},600).refreshAhead();ahead != STABLE_TOKEN_ROTATE_WINDOW{
+		test.Error("stable token ahead time should be in rotate window",ahead)
+	}
+}
+
+//使用accessToken调用模拟服务的普通接口，返回errcode
+func fakeApiErrcode(test *testing.T,baseURL,accessToken string) int64{
+	_,body,err := fasthttp.Get(nil,baseURL+"/cgi-bin/menu/get?access_token="+accessToken)
+	if err != nil{
+		test.Fatal(err)
+	}
+	return gjson.GetBytes(body,"errcode").Int()
+}
@@ -0,0 +1,104 @@
+package wechat
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+)
+
+//微信消息加解密，AES-256-CBC，key为EncodingAESKey base64解码后的32字节，iv为key的前16字节
+//明文格式：16字节随机串+4字节消息长度(网络字节序)+消息+appid
+
+const (
+	MSG_CRYPT_BLOCK_SIZE = 32
+)
+
+func msgAESKey(encodingAESKey string) ([]byte,error){
+	key,err := base64.StdEncoding.DecodeString(encodingAESKey+"=")
+	if err != nil{
+		return nil,err
+	}
+	if len(key) != 32{
+		return nil,errors.New("encodingAESKey invalid")
+	}
+	return key,nil
+}
+
+//消息签名，token，timestamp，nonce，encrypt字典序排序后sha1
+func MsgSignature(token,timestamp,nonce,encrypt string) string{
+	return sortedSha1(token,timestamp,nonce,encrypt)
+}
+
+//解密微信推送的消息，返回消息内容及消息中的appid
+func DecryptMsg(encodingAESKey,encrypt string) ([]byte,string,error){
+	key,err := msgAESKey(encodingAESKey)
+	if err != nil{
+		return nil,"",err
+	}
+	cipherText,err := base64.StdEncoding.DecodeString(encrypt)
+	if err != nil{
+		return nil,"",err
+	}
+	if len(cipherText) == 0 || len(cipherText)%aes.BlockSize != 0{
+		return nil,"",errors.New("encrypt msg length invalid")
+	}
+	block,err := aes.NewCipher(key)
+	if err != nil{
+		return nil,"",err
+	}
+	plain := make([]byte,len(cipherText))
+	cipher.NewCBCDecrypter(block,key[:aes.BlockSize]).CryptBlocks(plain,cipherText)
+
+	pad := int(plain[len(plain)-1])
+	if pad < 1 || pad > MSG_CRYPT_BLOCK_SIZE || pad > len(plain){
+		return nil,"",errors.New("decrypt msg padding invalid")
+	}
+	plain = plain[:len(plain)-pad]
+	if len(plain) < 20{
+		return nil,"",errors.New("decrypt msg length invalid")
+	}
+	msgLen := int(binary.BigEndian.Uint32(plain[16:20]))
+	if 20+msgLen > len(plain){
+		return nil,"",errors.New("decrypt msg length invalid")
+	}
+	return plain[20:20+msgLen],string(plain[20+msgLen:]),nil
+}
+
+//加密回复微信的消息
+func EncryptMsg(encodingAESKey,appid string,msg []byte) (string,error){
+	random := make([]byte,16)
+	if _,err := rand.Read(random);err != nil{
+		return "",err
+	}
+	return encryptMsg(encodingAESKey,random,appid,msg)
+}
+
+//random为明文开头的16字节随机串
+func encryptMsg(encodingAESKey string,random []byte,appid string,msg []byte) (string,error){
+	key,err := msgAESKey(encodingAESKey)
+	if err != nil{
+		return "",err
+	}
+	buf := bytes.Buffer{}
+	buf.Write(random)
+	msgLen := make([]byte,4)
+	binary.BigEndian.PutUint32(msgLen,uint32(len(msg)))
+	buf.Write(msgLen)
+	buf.Write(msg)
+	buf.WriteString(appid)
+
+	pad := MSG_CRYPT_BLOCK_SIZE-buf.Len()%MSG_CRYPT_BLOCK_SIZE
+	buf.Write(bytes.Repeat([]byte{byte(pad)},pad))
+
+	block,err := aes.NewCipher(key)
+	if err != nil{
+		return "",err
+	}
+	cipherText := make([]byte,buf.Len())
+	cipher.NewCBCEncrypter(block,key[:aes.BlockSize]).CryptBlocks(cipherText,buf.Bytes())
+	return base64.StdEncoding.EncodeToString(cipherText),nil
+}
@@ -0,0 +1,59 @@
+package wechat
+
+import "testing"
+
+const testEncodingAESKey = "abcdefghijklmnopqrstuvwxyz0123456789ABCDEFG"
+
+func TestMsgCrypt(test *testing.T){
+	msg := "<xml><InfoType>component_verify_ticket</InfoType></xml>"
+	encrypt,err := EncryptMsg(testEncodingAESKey,"wx_component",[]byte(msg))
+	if err != nil{
+		test.Fatal("encrypt msg error "+err.Error())
+	}
+	plain,appid,err := DecryptMsg(testEncodingAESKey,encrypt)
+	if err != nil{
+		test.Fatal("decrypt msg error "+err.Error())
+	}
+	if string(plain) != msg || appid != "wx_component"{
+		test.Error("decrypt msg not match")
+	}
+
+	if _,_,err := DecryptMsg("short",encrypt);err == nil{
+		test.Error("invalid encodingAESKey should error")
+	}
+}
+
+func TestMsgSignature(test *testing.T){
+	if MsgSignature("token","1","2","3") != MsgSignature("token","2","3","1"){
+		test.Error("msg signature should sort values")
+	}
+	if MsgSignature(docMsgToken,docMsgTimestamp,docMsgNonce,docMsgEncrypt) != docMsgSignature{
+		test.Error("msg signature not match wechat sample")
+	}
+}
+
+//微信官方消息加解密示例中的数据
+const (
+	docMsgToken = "spamtest"
+	docMsgTimestamp = "1409735669"
+	docMsgNonce = "1320562132"
+	docMsgAppID = "wx2c2769f8efd9abc2"
+	docMsgRandom = "89465c840c5f116f"
+	docMsgSignature = "5d197aaffba7e9b25a30732f161a50dee96bd5fa"
+	docMsgEncrypt = "hyzAe4OzmOMbd6TvGdIOO6uBmdJoD0Fk53REIHvxYtJlE2B655HuD0m8KUePWB3+LrPXo87wzQ1QLvbeUgmBM4x6F8PGHQHFVAFmOD2LdJF9FrXpbUAh0B5GIItb52sn896wVsMSHGuPE328HnRGBcrS7C41IzDWyWNlZkyyXwon8T332jisa+h6tEDYsVticbSnyU8dKOIbgU6ux5VTjg3yt+WGzjlpKn6NPhRjpA912xMezR4kw6KWwMrCVKSVCZciVGCgavjIQ6X8tCOp3yZbGpy0VxpAe+77TszTfRd5RJSVO/HTnifJpXgCSUdUue1v6h0EIBYYI1BD1DlD+C0CR8e6OewpusjZ4uBl9FyJvnhvQl+q5rv1ixrcpCumEPo5MJSgM9ehVsNPfUM669WuMyVWQLCzpu9GhglF2PE="
+	docMsg = "<xml><ToUserName><![CDATA[gh_10f6c3c3ac5a]]></ToUserName>\n<FromUserName><![CDATA[oyORnuP8q7ou2gfYjqLzSIWZf0rs]]></FromUserName>\n<CreateTime>1409735668</CreateTime>\n<MsgType><![CDATA[text]]></MsgType>\n<Content><![CDATA[abcdteT]]></Content>\n<MsgId>6054768590064713728</MsgId>\n</xml>"
+)
+
+func TestMsgCryptVector(test *testing.T){
+	plain,appid,err := DecryptMsg(testEncodingAESKey,docMsgEncrypt)
+	if err != nil{
+		test.Fatal("decrypt wechat sample error "+err.Error())
+	}
+	if string(plain) != docMsg || appid != docMsgAppID{
+		test.Error("decrypt wechat sample not match",string(plain),appid)
+	}
+	encrypt,err := encryptMsg(testEncodingAESKey,[]byte(docMsgRandom),docMsgAppID,[]byte(docMsg))
+	if err != nil || encrypt != docMsgEncrypt{
+		test.Error("encrypt wechat sample not match",encrypt,err)
+	}
+}
@@ -0,0 +1,58 @@
+package wechat
+
+import (
+	"github.com/dbldqt/util"
+	"log"
+	"strconv"
+)
+
+//订阅事件总线，当前节点刷新accessToken后请求应用配置的NotifyUrl
+//从快照、存储或leader同步的accessToken不通知，避免多个节点重复通知
+func (wm *WechatMan) startNotifier(){
+	go func(){
+		for{
+			sub := eventBus.SubscribeAll()
+			for event := range sub.C{
+				if event.Type == EVENT_TOKEN_ROTATED && event.Source == EVENT_SOURCE_REFRESH{
+					wm.notify(event)
+				}
+			}
+			log.Println("notifier subscription closed, resubscribe")
+		}
+	}()
+}
+
+func (wm *WechatMan) notifyUrls(appid,componentAppID string) []string{
+	wm.RLock()
+	defer wm.RUnlock()
+	for _,app := range wm.apps{
+		app.locker.RLock()
+		if app.WechatConfig.AppID == appid && app.WechatConfig.ComponentAppID == componentAppID{
+			urls := append([]string{},app.WechatConfig.NotifyUrl...)
+			app.locker.RUnlock()
+			return urls
+		}
+		app.locker.RUnlock()
+	}
+	return nil
+}
+
+//post参数：accessToken，updateTime，expires_in
+func (wm *WechatMan) notify(event *Event){
+	for _,url := range wm.notifyUrls(event.AppID,event.ComponentAppID){
+		if url == ""{
+			continue
+		}
+		go func(url string){
+			resp,err := util.PostFields(url,map[string]string{
+				"accessToken":event.AccessToken,
+				"updateTime":strconv.FormatInt(event.UpdateTime,10),
+				"expires_in":strconv.FormatInt(event.ExpireAt-event.UpdateTime,10),
+			})
+			if err != nil{
+				log.Println(url+" notify accessToken update err url:"+err.Error())
+			}
+			log.Println(url+" notify url response "+string(resp))
+		}(url)
+	}
+}
@@ -0,0 +1,91 @@
+package wechat
+
+import (
+	"encoding/json"
+	"github.com/dbldqt/wechatTokenServer/store"
+	"log"
+	"time"
+)
+
+//节点间同步的ticket
+type PeerTicket struct {
+	TicketType string `json:"ticketType"`
+	Ticket     string `json:"ticket"`
+	UpdateTime int64  `json:"updateTime"`
+	ExpireAt   int64  `json:"expireAt"`
+}
+
+//节点间同步的accessToken及其ticket
+type PeerToken struct {
+	store.Token
+	Tickets []*PeerTicket `json:"tickets,omitempty"`
+}
+
+//导出所有应用的accessToken和ticket，供follower同步
+func (wm *WechatMan) ExportPeerState() ([]byte,error){
+	tokens := make([]*PeerToken,0)
+	wm.RLock()
+	for _,app := range wm.apps{
+		app.locker.RLock()
+		if app.accessToken != "" && !app.deleted{
+			token := &PeerToken{Token:*app.storeToken()}
+			for _,ticket := range app.tickets{
+				if ticket.ticket == ""{
+					continue
+				}
+				token.Tickets = append(token.Tickets,&PeerTicket{
+					TicketType:ticket.ticketType,
+					Ticket:ticket.ticket,
+					UpdateTime:ticket.updateTime.Unix(),
+					ExpireAt:ticket.updateTime.Add(ticket.duration+time.Duration(app.aheadTime)*time.Second).Unix(),
+				})
+			}
+			tokens = append(tokens,token)
+		}
+		app.locker.RUnlock()
+	}
+	wm.RUnlock()
+	return json.Marshal(tokens)
+}
+
+//导入leader的accessToken和ticket，只更新比本地新的数据
+func (wm *WechatMan) ImportPeerState(state []byte) error{
+	tokens := make([]*PeerToken,0)
+	if err := json.Unmarshal(state,&tokens);err != nil{
+		return err
+	}
+	storeTokens := make([]*store.Token,0,len(tokens))
+	for _,token := range tokens{
+		storeTokens = append(storeTokens,&token.Token)
+	}
+	updated := wm.restoreTokens("peer",storeTokens...)
+
+	ticketUpdated := make([]*WechatApp,0)
+	wm.RLock()
+	for _,token := range tokens{
+		for _,app := range wm.apps{
+			if app.WechatConfig.AppID != token.AppID || app.WechatConfig.ComponentAppID != token.ComponentAppID{
+				continue
+			}
+			app.locker.Lock()
+			for _,item := range token.Tickets{
+				ticket,ok := app.tickets[item.TicketType]
+				if !ok || item.UpdateTime <= ticket.updateTime.Unix() || time.Now().Unix() >= item.ExpireAt{
+					continue
+				}
+				ticket.ticket = item.Ticket
+				ticket.updateTime = time.Unix(item.UpdateTime,0)
+				ticket.duration = time.Unix(item.ExpireAt,0).Sub(ticket.updateTime)-time.Duration(app.aheadTime)*time.Second
+				log.Println(app.WechatConfig.AppID+" load "+item.TicketType+" ticket from peer")
+				ticketUpdated = append(ticketUpdated,app)
+			}
+			app.locker.Unlock()
+		}
+	}
+	wm.RUnlock()
+	wm.reschedule(ticketUpdated...)
+	if len(updated) > 0{
+		wm.saveTokens(updated...)
+	}
+	return nil
+}
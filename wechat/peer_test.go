@@ -0,0 +1,34 @@
+package wechat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPeerState(test *testing.T){
+	conf := &WechatConfig{AppID:"wx_peer",AppSecret:"secret",Token:"token",JsapiTicket:true}
+	leader := &WechatMan{apps:[]*WechatApp{NewWechatApp(conf,600)}}
+	app := leader.apps[0]
+	app.accessToken = "leader_token"
+	app.updateTime = time.Now()
+	app.duration = 6600*time.Second
+	ticket := app.tickets[TICKET_TYPE_JSAPI]
+	ticket.ticket = "leader_ticket"
+	ticket.updateTime = app.updateTime
+	ticket.duration = 6600*time.Second
+
+	state,err := leader.ExportPeerState()
+	if err != nil{
+		test.Fatal(err)
+	}
+	follower := &WechatMan{apps:[]*WechatApp{NewWechatApp(conf,600)}}
+	if err := follower.ImportPeerState(state);err != nil{
+		test.Fatal(err)
+	}
+	if token,_,err := follower.QueryAccessToken("wx_peer","token");err != nil || token != "leader_token"{
+		test.Error("follower should use leader token")
+	}
+	if ticket,_,err := follower.QueryTicket("wx_peer","token",TICKET_TYPE_JSAPI);err != nil || ticket != "leader_ticket"{
+		test.Error("follower should use leader ticket")
+	}
+}
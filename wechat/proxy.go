@@ -0,0 +1,104 @@
+package wechat
+
+import (
+	"github.com/dbldqt/wechatTokenServer/store"
+	"log"
+	"sync"
+	"time"
+)
+
+//代理请求微信接口需要的信息，accessToken只在服务端使用，不返回给调用方
+type ProxyTarget struct {
+	BaseURL     string
+	TokenParam  string //第三方平台接口使用component_access_token参数
+	AccessToken string
+}
+
+//正在刷新的accessToken，key为应用的store.TokenKey，value为触发刷新的旧accessToken
+var proxyRefreshing = struct {
+	sync.Mutex
+	stale map[string]string
+}{stale:make(map[string]string)}
+
+func (wa *WechatApp) proxyTarget() *ProxyTarget{
+	target := &ProxyTarget{BaseURL:wa.WechatConfig.apiBase(),TokenParam:"access_token",AccessToken:wa.accessToken}
+	if wa.IsComponent(){
+		target.TokenParam = "component_access_token"
+	}
+	return target
+}
+
+//查询通过token校验的应用，不同第三方平台下的同一个授权方appid使用各自的token区分
+func (wm *WechatMan) getAppByToken(appid,token string) *WechatApp{
+	wm.RLock()
+	defer wm.RUnlock()
+	for _,app := range wm.apps{
+		app.locker.RLock()
+		matched := app.WechatConfig.AppID == appid && app.WechatConfig.Token == token
+		app.locker.RUnlock()
+		if matched{
+			return app
+		}
+	}
+	return nil
+}
+
+//查询代理请求的目标，已删除的应用不再代理
+func (wm *WechatMan) QueryProxyTarget(appid,token string) (*ProxyTarget,error){
+	wm.RLock()
+	defer wm.RUnlock()
+	for _,app := range wm.apps{
+		app.locker.RLock()
+		if app.WechatConfig.AppID == appid && app.WechatConfig.Token == token{
+			target,deleted := app.proxyTarget(),app.deleted
+			app.locker.RUnlock()
+			if deleted{
+				return nil,ErrAppDeleted
+			}
+			if target.AccessToken == ""{
+				return nil,ErrTokenNotReady
+			}
+			return target,nil
+		}
+		app.locker.RUnlock()
+	}
+	return nil,ErrAppNotFound
+}
+
+//代理请求返回accessToken无效时调用，stale为失效的accessToken
+//同一个应用的同一个stale只强制刷新一次，只刷新通过token校验的应用，然后等待更新的accessToken，超时返回ErrWaitTimeout
+func (wm *WechatMan) RefreshStaleAccessToken(appid,token,stale string,timeout time.Duration) (*ProxyTarget,error){
+	_,newer,err := wm.newerAccessToken(appid,token,stale,0)
+	if err != nil{
+		return nil,err
+	}
+	//其他请求已经刷新过时直接使用新的accessToken
+	if !newer{
+		app := wm.getAppByToken(appid,token)
+		if app == nil{
+			return nil,ErrAppNotFound
+		}
+		key := store.TokenKey(app.WechatConfig.ComponentAppID,appid)
+		proxyRefreshing.Lock()
+		if proxyRefreshing.stale[key] != stale{
+			proxyRefreshing.stale[key] = stale
+			go func(){
+				if wm.IsLeader(){
+					wm.forceRefreshApps(app)
+				}else{
+					log.Println("follower can't refresh accesstoken, wait for leader")
+				}
+				proxyRefreshing.Lock()
+				if proxyRefreshing.stale[key] == stale{
+					delete(proxyRefreshing.stale,key)
+				}
+				proxyRefreshing.Unlock()
+			}()
+		}
+		proxyRefreshing.Unlock()
+		if _,err = wm.WaitAccessToken(appid,token,stale,0,timeout);err != nil{
+			return nil,err
+		}
+	}
+	return wm.QueryProxyTarget(appid,token)
+}
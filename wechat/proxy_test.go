@@ -0,0 +1,112 @@
+package wechat
+
+import (
+	"github.com/tidwall/gjson"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type followerChecker struct{}
+
+func (followerChecker) IsLeader() bool{
+	return false
+}
+
+func TestQueryProxyTarget(test *testing.T){
+	wecom := NewWechatApp(&WechatConfig{AppID:"wx_proxy_wecom",AppSecret:"secret",Token:"token",Provider:PROVIDER_WECOM},600)
+	wecom.accessToken = "wecom_token"
+	component := NewWechatApp(&WechatConfig{AppID:"wx_proxy_component",Token:"token",Type:APP_TYPE_COMPONENT},600)
+	component.accessToken = "component_token"
+	waiting := NewWechatApp(&WechatConfig{AppID:"wx_proxy_waiting",AppSecret:"secret",Token:"token"},600)
+	wm := &WechatMan{apps:[]*WechatApp{wecom,component,waiting}}
+
+	target,err := wm.QueryProxyTarget("wx_proxy_wecom","token")
+	if err != nil || target.BaseURL != WECOM_API_BASE || target.TokenParam != "access_token" || target.AccessToken != "wecom_token"{
+		test.Error("wecom proxy target error",target,err)
+	}
+	target,err = wm.QueryProxyTarget("wx_proxy_component","token")
+	if err != nil || target.BaseURL != WECHAT_API_BASE || target.TokenParam != "component_access_token"{
+		test.Error("component proxy target error",target,err)
+	}
+	if _,err = wm.QueryProxyTarget("wx_proxy_waiting","token");err != ErrTokenNotReady{
+		test.Error("empty accesstoken should not ready",err)
+	}
+	if _,err = wm.QueryProxyTarget("wx_proxy_wecom","wrong");err != ErrAppNotFound{
+		test.Error("wrong token should not found app",err)
+	}
+	wecom.deleted = true
+	if _,err = wm.QueryProxyTarget("wx_proxy_wecom","token");err != ErrAppDeleted{
+		test.Error("deleted app should not proxy",err)
+	}
+}
+
+func TestRefreshStaleAccessToken(test *testing.T){
+	app := NewWechatApp(&WechatConfig{AppID:"wx_proxy_stale",AppSecret:"secret",Token:"token"},600)
+	app.accessToken = "new_token"
+	app.updateTime = time.Now()
+	app.duration = 6600*time.Second
+	wm := &WechatMan{apps:[]*WechatApp{app},leader:followerChecker{}}
+
+	//其他请求已经刷新，直接返回新的accessToken
+	target,err := wm.RefreshStaleAccessToken("wx_proxy_stale","token","old_token",time.Second)
+	if err != nil || target.AccessToken != "new_token"{
+		test.Error("newer accesstoken should return directly",target,err)
+	}
+	//follower不能刷新，等待超时
+	if _,err = wm.RefreshStaleAccessToken("wx_proxy_stale","token","new_token",50*time.Millisecond);err != ErrWaitTimeout{
+		test.Error("follower refresh should timeout",err)
+	}
+}
+
+//不同第三方平台下同一个授权方appid，只刷新通过token校验的那个应用
+func TestRefreshStaleAuthorizer(test *testing.T){
+	var requested []string
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,r *http.Request){
+		body,_ := ioutil.ReadAll(r.Body)
+		componentAppID := gjson.GetBytes(body,"component_appid").String()
+		mu.Lock()
+		requested = append(requested,componentAppID)
+		mu.Unlock()
+		w.Write([]byte(`{"authorizer_access_token":"new_`+componentAppID+`","expires_in":7200}`))
+	}))
+	defer server.Close()
+	dir,err := ioutil.TempDir("","wechatman")
+	if err != nil{
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wm := newTestWechatMan(600,60)
+	authorizers := make([]*WechatApp,0)
+	for _,componentAppID := range []string{"wx_stale_component_a","wx_stale_component_b"}{
+		component := NewWechatApp(&WechatConfig{AppID:componentAppID,AppSecret:"secret",Token:componentAppID,Type:APP_TYPE_COMPONENT,ApiBaseURL:server.URL,
+			VerifyTicketFile:filepath.Join(dir,componentAppID+"_ticket"),AuthorizerFile:filepath.Join(dir,componentAppID+"_authorizers")},600)
+		component.accessToken = "component_token"
+		component.authorizers["wx_stale_shared"] = "refresh_token"
+		authorizer := component.newAuthorizerApp("wx_stale_shared")
+		authorizer.accessToken = "old_token"
+		authorizer.updateTime = time.Now()
+		authorizer.duration = 6600*time.Second
+		wm.apps = append(wm.apps,component,authorizer)
+		authorizers = append(authorizers,authorizer)
+	}
+
+	target,err := wm.RefreshStaleAccessToken("wx_stale_shared","wx_stale_component_a","old_token",time.Second)
+	if err != nil || target.AccessToken != "new_wx_stale_component_a"{
+		test.Fatal("stale authorizer should be refreshed",target,err)
+	}
+	authorizers[1].locker.RLock()
+	other := authorizers[1].accessToken
+	authorizers[1].locker.RUnlock()
+	mu.Lock()
+	defer mu.Unlock()
+	if other != "old_token" || len(requested) != 1 || requested[0] != "wx_stale_component_a"{
+		test.Error("only the verified authorizer should be refreshed",other,requested)
+	}
+}
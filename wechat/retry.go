@@ -0,0 +1,150 @@
+package wechat
+
+import (
+	"log"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//刷新accessToken失败后的重试策略，重试间隔从BaseDelay开始指数增长，不超过MaxDelay，并加入随机抖动
+type RetryPolicy struct {
+	MaxAttempts int           //连续失败的最大次数，达到后不再重试，等待LoopTime后再检查
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts:6,BaseDelay:2*time.Second,MaxDelay:5*time.Minute}
+
+//未设置的字段使用默认值
+func (rp RetryPolicy) withDefaults() RetryPolicy{
+	if rp.MaxAttempts <= 0{
+		rp.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if rp.BaseDelay <= 0{
+		rp.BaseDelay = DefaultRetryPolicy.BaseDelay
+	}
+	if rp.MaxDelay <= 0{
+		rp.MaxDelay = DefaultRetryPolicy.MaxDelay
+	}
+	if rp.MaxDelay < rp.BaseDelay{
+		rp.MaxDelay = rp.BaseDelay
+	}
+	return rp
+}
+
+//第failures次失败后的重试间隔，在[d/2,d)之间随机，避免多个应用同时重试
+func (rp RetryPolicy) delay(failures int) time.Duration{
+	d := rp.BaseDelay
+	for i := 1;i < failures && d < rp.MaxDelay;i++{
+		d *= 2
+	}
+	if d > rp.MaxDelay{
+		d = rp.MaxDelay
+	}
+	return d/2+time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (wm *WechatMan) SetRetryPolicy(rp RetryPolicy){
+	wm.Lock()
+	wm.retry = rp.withDefaults()
+	wm.Unlock()
+}
+
+func (wm *WechatMan) retryPolicy() RetryPolicy{
+	wm.RLock()
+	defer wm.RUnlock()
+	return wm.retry.withDefaults()
+}
+
+//调用方需要持有wa的锁
+func (wa *WechatApp) cancelRetry(){
+	if wa.retryTimer != nil{
+		wa.retryTimer.Stop()
+		wa.retryTimer = nil
+	}
+}
+
+//重新加载配置时清除失败状态，配置可能已经修正
+func (wa *WechatApp) resetRetry(){
+	wa.cancelRetry()
+	wa.failures = 0
+	wa.permanentFailure = false
+}
+
+//刷新accessToken，成功后按照新的到期时间重新调度，失败时按照重试策略安排重试
+func (wm *WechatMan) updateAccessToken(app *WechatApp,forceRefresh bool,wg *sync.WaitGroup){
+	defer wg.Done()
+	//强制刷新重新开始计算失败次数
+	if forceRefresh{
+		app.locker.Lock()
+		app.resetRetry()
+		app.locker.Unlock()
+	}
+	errcode,ok := app.refresh(forceRefresh)
+	if ok{
+		//expires_in不大于AheadTime时到期时间已经过去，等待LoopTime后再刷新，避免反复请求
+		wm.rescheduleLater(app)
+		//第三方平台的component_access_token更新后，授权方可以刷新
+		if app.IsComponent(){
+			wm.reschedule(wm.authorizersOf(app)...)
+		}
+		return
+	}
+	if wm.scheduleRetry(app,errcode){
+		wm.sched.remove(app)
+	}else{
+		wm.rescheduleLater(app)
+	}
+}
+
+//安排重试，配置错误或者达到最大次数时返回false
+func (wm *WechatMan) scheduleRetry(app *WechatApp,errcode int) bool{
+	policy := wm.retryPolicy()
+	app.locker.Lock()
+	defer app.locker.Unlock()
+	appid := app.WechatConfig.AppID
+	if app.deleted{
+		return false
+	}
+	if app.retryTimer != nil{
+		return true
+	}
+	if app.permanentFailure{
+		log.Println(appid+" refresh accesstoken errcode "+strconv.Itoa(errcode)+" can't be retried, fix config and reload or force refresh")
+		return false
+	}
+	if app.failures >= policy.MaxAttempts{
+		log.Println(appid+" refresh accesstoken failed "+strconv.Itoa(app.failures)+" times, check again after looptime")
+		return false
+	}
+	delay := policy.delay(app.failures)
+	log.Println(appid+" retry refresh accesstoken after "+delay.String())
+	app.retryTimer = time.AfterFunc(delay,func(){
+		wm.retryAccessToken(app)
+	})
+	return true
+}
+
+func (wm *WechatMan) retryAccessToken(app *WechatApp){
+	app.locker.Lock()
+	app.retryTimer = nil
+	deleted := app.deleted
+	app.locker.Unlock()
+	//定时器已经触发时应用才被删除，不再刷新和调度
+	if deleted{
+		return
+	}
+	wm.RLock()
+	running := wm.isRuning
+	wm.RUnlock()
+	if !running || !wm.IsLeader(){
+		wm.rescheduleLater(app)
+		return
+	}
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	wm.updateAccessToken(app,false,&wg)
+	wm.saveTokens(app)
+}
@@ -0,0 +1,141 @@
+package wechat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(test *testing.T){
+	policy := RetryPolicy{BaseDelay:time.Second,MaxDelay:10*time.Second}.withDefaults()
+	if policy.MaxAttempts != DefaultRetryPolicy.MaxAttempts{
+		test.Error("default max attempts error",policy)
+	}
+	for failures,max := range []time.Duration{1:time.Second,2:2*time.Second,3:4*time.Second,4:8*time.Second,5:10*time.Second,6:10*time.Second}{
+		if failures == 0{
+			continue
+		}
+		for i := 0;i < 20;i++{
+			if delay := policy.delay(failures);delay < max/2 || delay > max{
+				test.Errorf("delay of %d failures %s,want %s to %s",failures,delay,max/2,max)
+			}
+		}
+	}
+	for errcode,retryable := range map[int]bool{-1:true,45009:true,40001:true,40013:false,40125:false,40164:false}{
+		if IsRetryableErrcode(PROVIDER_WECHAT,errcode) != retryable{
+			test.Errorf("errcode %d retryable should be %v",errcode,retryable)
+		}
+	}
+	//企业微信40001为secret错误，60020为ip不在白名单
+	for errcode,retryable := range map[int]bool{-1:true,45009:true,40001:false,40013:false,60020:false}{
+		if IsRetryableErrcode(PROVIDER_WECOM,errcode) != retryable{
+			test.Errorf("wecom errcode %d retryable should be %v",errcode,retryable)
+		}
+	}
+}
+
+func TestRefreshRetry(test *testing.T){
+	fake := newFakeWechat(test,"wx_retry")
+	defer fake.Close()
+	wm := newTestWechatMan(600,60,&WechatConfig{AppID:"wx_retry",AppSecret:"secret",Token:"token",ApiBaseURL:fake.URL()})
+	wm.retry = RetryPolicy{MaxAttempts:3,BaseDelay:20*time.Millisecond,MaxDelay:50*time.Millisecond}
+	sub := eventBus.Subscribe("wx_retry")
+	defer sub.Close()
+
+	//达到最大次数后不再重试，等待下次循环
+	fake.FailNext(-1,45009,-1,-1)
+	wm.Run()
+	defer wm.Stop()
+	for i := 0;i < 3;i++{
+		waitEvent(test,sub,EVENT_REFRESH_FAILED,time.Second)
+	}
+	time.Sleep(200*time.Millisecond)
+	if fake.Requests("wx_retry") != 3{
+		test.Error("retry should stop after max attempts",fake.Requests("wx_retry"))
+	}
+	fake.FailNext(-1)
+	wm.ForceRefreshAccessToken("wx_retry")
+	if event := waitEvent(test,sub,EVENT_TOKEN_ROTATED,time.Second);event.AccessToken != fake.AccessToken("wx_retry"){
+		test.Error("retry after force refresh error",event)
+	}
+
+	//配置错误不重试
+	fake.FailNext(40164)
+	wm.ForceRefreshAccessToken("wx_retry")
+	if event := waitEvent(test,sub,EVENT_REFRESH_FAILED,time.Second);event.Errcode != 40164{
+		test.Error("permanent errcode error",event)
+	}
+	requests := fake.Requests("wx_retry")
+	time.Sleep(200*time.Millisecond)
+	if fake.Requests("wx_retry") != requests || !wm.apps[0].permanentFailure{
+		test.Error("permanent errcode should not retry",fake.Requests("wx_retry")-requests)
+	}
+	//重新加载配置后清除失败状态
+	if err := wm.Rebuild(600,60,&WechatConfig{AppID:"wx_retry",AppSecret:"secret",Token:"token",ApiBaseURL:fake.URL()});err != nil{
+		test.Fatal(err)
+	}
+	if wm.apps[0].permanentFailure || wm.apps[0].failures != 0{
+		test.Error("rebuild should reset retry state")
+	}
+}
+
+//网络错误时ForceRefreshAccessToken不能阻塞
+func TestRefreshNetworkError(test *testing.T){
+	//1端口没有服务监听，连接被拒绝
+	wm := newTestWechatMan(600,60,&WechatConfig{AppID:"wx_network",AppSecret:"secret",Token:"token",ApiBaseURL:"http://127.0.0.1:1"})
+	done := make(chan int)
+	go func(){
+		wm.ForceRefreshAccessToken("wx_network")
+		close(done)
+	}()
+	select{
+	case <-done:
+	case <-time.After(5*time.Second):
+		test.Fatal("force refresh blocked on network error")
+	}
+	app := wm.apps[0]
+	app.locker.Lock()
+	defer app.locker.Unlock()
+	if app.failures != 1 || app.permanentFailure || app.retryTimer == nil{
+		test.Error("network error should be retried",app.failures)
+	}
+	app.cancelRetry()
+}
+
+//企业微信的配置错误同样不重试
+func TestWecomRefreshPermanentError(test *testing.T){
+	fake := newFakeWechat(test,"ww_retry")
+	defer fake.Close()
+	wm := newTestWechatMan(600,60,&WechatConfig{AppID:"ww_retry",AppSecret:"secret",Token:"token",Provider:PROVIDER_WECOM,ApiBaseURL:fake.URL()})
+	wm.retry = RetryPolicy{MaxAttempts:3,BaseDelay:20*time.Millisecond,MaxDelay:50*time.Millisecond}
+	fake.FailNext(60020)
+	wm.ForceRefreshAccessToken("ww_retry")
+	time.Sleep(200*time.Millisecond)
+	app := wm.apps[0]
+	app.locker.Lock()
+	defer app.locker.Unlock()
+	if fake.Requests("ww_retry") != 1 || !app.permanentFailure || app.retryTimer != nil{
+		test.Error("wecom permanent errcode should not retry",fake.Requests("ww_retry"))
+	}
+}
+
+//删除应用后取消等待中的重试
+func TestDeleteAppCancelRetry(test *testing.T){
+	fake := newFakeWechat(test,"wx_retry_deleted")
+	defer fake.Close()
+	wm := newTestWechatMan(600,60,&WechatConfig{AppID:"wx_retry_deleted",AppSecret:"secret",Token:"token",ApiBaseURL:fake.URL()})
+	wm.retry = RetryPolicy{MaxAttempts:3,BaseDelay:50*time.Millisecond,MaxDelay:50*time.Millisecond}
+	app := wm.apps[0]
+	fake.FailNext(-1)
+	wm.ForceRefreshAccessToken("wx_retry_deleted")
+	wm.DelWechatAppByAppID("wx_retry_deleted")
+	time.Sleep(200*time.Millisecond)
+	app.locker.RLock()
+	retrying := app.retryTimer != nil
+	app.locker.RUnlock()
+	wm.sched.Lock()
+	_,scheduled := wm.sched.byApp[app]
+	wm.sched.Unlock()
+	if fake.Requests("wx_retry_deleted") != 1 || retrying || scheduled{
+		test.Error("deleted app should not be retried",fake.Requests("wx_retry_deleted"),retrying,scheduled)
+	}
+}
@@ -0,0 +1,285 @@
+package wechat
+
+import (
+	"container/heap"
+	"log"
+	"sync"
+	"time"
+)
+
+//按照每个应用的到期时间调度刷新，最小堆的堆顶为最早到期的应用，只为堆顶设置一个定时器，空闲时不占用cpu
+type schedEntry struct {
+	app   *WechatApp
+	at    time.Time
+	index int
+}
+
+type schedHeap []*schedEntry
+
+func (h schedHeap) Len() int{
+	return len(h)
+}
+
+func (h schedHeap) Less(i,j int) bool{
+	return h[i].at.Before(h[j].at)
+}
+
+func (h schedHeap) Swap(i,j int){
+	h[i],h[j] = h[j],h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *schedHeap) Push(x interface{}){
+	entry := x.(*schedEntry)
+	entry.index = len(*h)
+	*h = append(*h,entry)
+}
+
+func (h *schedHeap) Pop() interface{}{
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return entry
+}
+
+type scheduler struct {
+	sync.Mutex
+	entries schedHeap
+	byApp   map[*WechatApp]*schedEntry
+	recheck time.Duration //暂时不能刷新的应用再次检查的间隔，使用LoopTime
+	wake    chan int      //堆顶变化时唤醒调度协程
+}
+
+func newScheduler() *scheduler{
+	return &scheduler{
+		byApp:make(map[*WechatApp]*schedEntry),
+		wake:make(chan int,1),
+	}
+}
+
+//设置或者修改应用的到期时间，WechatMan未初始化scheduler时忽略
+func (s *scheduler) schedule(app *WechatApp,at time.Time){
+	if s == nil{
+		return
+	}
+	s.Lock()
+	if entry,ok := s.byApp[app];ok{
+		entry.at = at
+		heap.Fix(&s.entries,entry.index)
+	}else{
+		entry = &schedEntry{app:app,at:at}
+		heap.Push(&s.entries,entry)
+		s.byApp[app] = entry
+	}
+	s.Unlock()
+	s.notify()
+}
+
+func (s *scheduler) remove(app *WechatApp){
+	if s == nil{
+		return
+	}
+	s.Lock()
+	if entry,ok := s.byApp[app];ok{
+		heap.Remove(&s.entries,entry.index)
+		delete(s.byApp,app)
+	}
+	s.Unlock()
+	s.notify()
+}
+
+func (s *scheduler) clear(){
+	if s == nil{
+		return
+	}
+	s.Lock()
+	s.entries = nil
+	s.byApp = make(map[*WechatApp]*schedEntry)
+	s.Unlock()
+}
+
+func (s *scheduler) notify(){
+	select{
+	case s.wake<-1:
+	default:
+	}
+}
+
+//最早的到期时间
+func (s *scheduler) next() (time.Time,bool){
+	s.Lock()
+	defer s.Unlock()
+	if len(s.entries) == 0{
+		return time.Time{},false
+	}
+	return s.entries[0].at,true
+}
+
+//取出所有已经到期的应用，处理完成后由处理方重新安排
+func (s *scheduler) popDue(now time.Time) []*WechatApp{
+	s.Lock()
+	defer s.Unlock()
+	apps := make([]*WechatApp,0)
+	for len(s.entries) > 0 && !s.entries[0].at.After(now){
+		entry := heap.Pop(&s.entries).(*schedEntry)
+		delete(s.byApp,entry.app)
+		apps = append(apps,entry.app)
+	}
+	return apps
+}
+
+func (s *scheduler) setRecheck(recheck time.Duration){
+	s.Lock()
+	s.recheck = recheck
+	s.Unlock()
+}
+
+func (s *scheduler) recheckAfter() time.Duration{
+	s.Lock()
+	defer s.Unlock()
+	return s.recheck
+}
+
+//调用方需要持有wa的锁，返回下次需要处理的时间，accessToken和ticket中较早到期的一个
+//已删除、等待重试以及配置错误的应用不需要调度，false
+func (wa *WechatApp) nextRefresh() (time.Time,bool){
+	if wa.deleted || wa.retryTimer != nil || wa.permanentFailure && !wa.needUpdate{
+		return time.Time{},false
+	}
+	if wa.needUpdate || wa.accessToken == ""{
+		return time.Now(),true
+	}
+	at := wa.updateTime.Add(wa.duration)
+	for _,ticket := range wa.tickets{
+		if due := ticket.updateTime.Add(ticket.duration);due.Before(at){
+			at = due
+		}
+	}
+	return at,true
+}
+
+//从wm.apps中移除的应用标记为删除并取消等待中的重试，正在进行的刷新完成后不再更新和调度，调用方不能持有app的锁
+func (wm *WechatMan) detachApp(app *WechatApp){
+	app.locker.Lock()
+	app.deleted = true
+	app.cancelRetry()
+	app.locker.Unlock()
+	wm.sched.remove(app)
+}
+
+//根据应用当前的状态重新安排，accessToken更新、配置变化、应用删除后调用
+//持有app的读锁调度，与detachApp互斥，已删除的应用不会被重新加入
+func (wm *WechatMan) reschedule(apps ...*WechatApp){
+	for _,app := range apps{
+		app.locker.RLock()
+		if at,ok := app.nextRefresh();ok{
+			wm.sched.schedule(app,at)
+		}else{
+			wm.sched.remove(app)
+		}
+		app.locker.RUnlock()
+	}
+}
+
+//刚处理过仍然到期的应用，例如第三方平台未收到component_verify_ticket、follower存储中没有新的accessToken、
+//刷新后expires_in不大于AheadTime，等待LoopTime后再检查，避免被反复调度
+func (wm *WechatMan) rescheduleLater(apps ...*WechatApp){
+	if wm.sched == nil{
+		return
+	}
+	recheck := wm.sched.recheckAfter()
+	for _,app := range apps{
+		app.locker.RLock()
+		at,ok := app.nextRefresh()
+		if !ok{
+			wm.sched.remove(app)
+			app.locker.RUnlock()
+			continue
+		}
+		if now := time.Now();!at.After(now){
+			at = now.Add(recheck)
+		}
+		wm.sched.schedule(app,at)
+		app.locker.RUnlock()
+	}
+}
+
+func (wm *WechatMan) runScheduler(stopCh <-chan int){
+	go func(){
+		log.Println("scheduler routine start")
+		timer := time.NewTimer(time.Hour)
+		timer.Stop()
+		for{
+			var timeout <-chan time.Time
+			if at,ok := wm.sched.next();ok{
+				if !timer.Stop(){
+					select{
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(time.Until(at))
+				timeout = timer.C
+			}
+			select{
+			case <-stopCh:
+				timer.Stop()
+				log.Println("scheduler routine end")
+				return
+			case <-wm.sched.wake:
+			case <-timeout:
+				if apps := wm.sched.popDue(time.Now());len(apps) > 0{
+					go wm.refreshApps(apps)
+				}
+			}
+		}
+	}()
+}
+
+//处理到期的应用，follower从存储中同步leader的accessToken
+func (wm *WechatMan) refreshApps(apps []*WechatApp){
+	if !wm.IsLeader(){
+		if err := wm.LoadTokenStore();err != nil{
+			log.Println("follower sync token error "+err.Error())
+		}
+		wm.rescheduleLater(apps...)
+		return
+	}
+	wg := sync.WaitGroup{}
+	updated := make([]*WechatApp,0)
+	others := make([]*WechatApp,0)
+	for _,app := range apps{
+		app.locker.RLock()
+		if app.deleted{
+			app.locker.RUnlock()
+			continue
+		}
+		tokenDue := app.needUpdate || app.accessToken == "" || time.Since(app.updateTime) >= app.duration
+		if tokenDue && app.retryTimer == nil && !(app.permanentFailure && !app.needUpdate) && app.canRequestAccessToken(){
+			//刷新完成后在updateAccessToken中重新安排
+			wg.Add(1)
+			updated = append(updated,app)
+			app.locker.RUnlock()
+			go wm.updateAccessToken(app,false,&wg)
+			continue
+		}
+		if !tokenDue{
+			//accessToken有效时，单独检查ticket是否需要更新
+			for ticketType,ticket := range app.tickets{
+				if ticket.needUpdate(){
+					wg.Add(1)
+					go app.UpdateTicket(ticketType,&wg)
+				}
+			}
+		}
+		others = append(others,app)
+		app.locker.RUnlock()
+	}
+	wg.Wait()
+	if len(updated) > 0{
+		wm.saveTokens(updated...)
+	}
+	wm.rescheduleLater(others...)
+}
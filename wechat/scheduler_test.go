@@ -0,0 +1,166 @@
+package wechat
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestScheduler(test *testing.T){
+	s := newScheduler()
+	now := time.Now()
+	a := NewWechatApp(&WechatConfig{AppID:"wx_sched_a"},600)
+	b := NewWechatApp(&WechatConfig{AppID:"wx_sched_b"},600)
+	c := NewWechatApp(&WechatConfig{AppID:"wx_sched_c"},600)
+	s.schedule(a,now.Add(3*time.Second))
+	s.schedule(b,now.Add(time.Second))
+	s.schedule(c,now.Add(2*time.Second))
+	if at,ok := s.next();!ok || !at.Equal(now.Add(time.Second)){
+		test.Error("next should be the earliest",at)
+	}
+	//修改到期时间后调整位置
+	s.schedule(a,now.Add(-time.Second))
+	s.remove(c)
+	apps := s.popDue(now.Add(time.Second))
+	if len(apps) != 2 || apps[0] != a || apps[1] != b{
+		test.Error("pop due apps error",apps)
+	}
+	if _,ok := s.next();ok || len(s.byApp) != 0{
+		test.Error("scheduler should be empty")
+	}
+}
+
+func TestNextRefresh(test *testing.T){
+	app := NewWechatApp(&WechatConfig{AppID:"wx_next",JsapiTicket:true},600)
+	if at,ok := app.nextRefresh();!ok || time.Since(at) > time.Second{
+		test.Error("app without accesstoken should refresh now",at)
+	}
+	app.accessToken = "token"
+	app.updateTime = time.Now()
+	app.duration = time.Hour
+	app.tickets[TICKET_TYPE_JSAPI].updateTime = app.updateTime
+	app.tickets[TICKET_TYPE_JSAPI].duration = time.Minute
+	if at,ok := app.nextRefresh();!ok || !at.Equal(app.updateTime.Add(time.Minute)){
+		test.Error("ticket expires earlier",at)
+	}
+	app.permanentFailure = true
+	if _,ok := app.nextRefresh();ok{
+		test.Error("permanent failure should not be scheduled")
+	}
+	app.needUpdate = true
+	if _,ok := app.nextRefresh();!ok{
+		test.Error("config changed should be scheduled")
+	}
+	app.deleted = true
+	if _,ok := app.nextRefresh();ok{
+		test.Error("deleted app should not be scheduled")
+	}
+}
+
+//accessToken在到期时刷新，不依赖LoopTime
+func TestSchedulerRefreshOnTime(test *testing.T){
+	fake := newFakeWechat(test,"wx_ontime","wx_ontime_deleted")
+	defer fake.Close()
+	fake.SetExpiresIn(2)
+	wm := newTestWechatMan(1,3600,
+		&WechatConfig{AppID:"wx_ontime",AppSecret:"secret",Token:"token",ApiBaseURL:fake.URL()},
+		&WechatConfig{AppID:"wx_ontime_deleted",AppSecret:"secret",Token:"token",ApiBaseURL:fake.URL()})
+	sub := eventBus.Subscribe("wx_ontime")
+	defer sub.Close()
+	wm.Run()
+	defer wm.Stop()
+
+	first := waitEvent(test,sub,EVENT_TOKEN_ROTATED,time.Second)
+	app := wm.apps[0]
+	app.locker.RLock()
+	due := app.updateTime.Add(app.duration)
+	app.locker.RUnlock()
+	wm.sched.Lock()
+	entry,ok := wm.sched.byApp[app]
+	wm.sched.Unlock()
+	if !ok || !entry.at.Equal(due){
+		test.Error("app should be scheduled at updateTime+duration",due)
+	}
+	second := waitEvent(test,sub,EVENT_TOKEN_ROTATED,2*time.Second)
+	if second.AccessToken == first.AccessToken || time.Since(due) > 500*time.Millisecond{
+		test.Error("accesstoken should refresh on time",time.Since(due))
+	}
+
+	//删除的应用不再调度
+	if err := wm.Rebuild(1,3600,&WechatConfig{AppID:"wx_ontime",AppSecret:"secret",Token:"token",ApiBaseURL:fake.URL()});err != nil{
+		test.Fatal(err)
+	}
+	wm.sched.Lock()
+	_,deletedScheduled := wm.sched.byApp[wm.apps[1]]
+	_,scheduled := wm.sched.byApp[app]
+	wm.sched.Unlock()
+	if deletedScheduled || !scheduled{
+		test.Error("rebuild should reschedule apps",deletedScheduled,scheduled)
+	}
+}
+
+//expires_in不大于AheadTime时，刷新后等待LoopTime再刷新，不会反复请求
+func TestSchedulerExpiresWithinAheadTime(test *testing.T){
+	fake := newFakeWechat(test,"wx_short")
+	defer fake.Close()
+	fake.SetExpiresIn(600)
+	wm := newTestWechatMan(600,60,&WechatConfig{AppID:"wx_short",AppSecret:"secret",Token:"token",ApiBaseURL:fake.URL()})
+	sub := eventBus.Subscribe("wx_short")
+	defer sub.Close()
+	wm.Run()
+	defer wm.Stop()
+
+	waitEvent(test,sub,EVENT_TOKEN_ROTATED,time.Second)
+	time.Sleep(time.Second)
+	if requests := fake.Requests("wx_short");requests != 1{
+		test.Error("accesstoken should be requested once",requests)
+	}
+	wm.sched.Lock()
+	entry,ok := wm.sched.byApp[wm.apps[0]]
+	wm.sched.Unlock()
+	if !ok || time.Until(entry.at) < 58*time.Second{
+		test.Error("app should be rescheduled after looptime")
+	}
+}
+
+//删除应用时正在进行的刷新完成后不再更新accessToken，也不会重新加入调度
+func TestDeleteAppDuringRefresh(test *testing.T){
+	received := make(chan int)
+	release := make(chan int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,r *http.Request){
+		received<-1
+		<-release
+		w.Write([]byte(`{"access_token":"deleted_token","expires_in":7200}`))
+	}))
+	defer server.Close()
+	wm := newTestWechatMan(600,60,&WechatConfig{AppID:"wx_delete_refresh",AppSecret:"secret",Token:"token",ApiBaseURL:server.URL})
+	app := wm.apps[0]
+	sub := eventBus.Subscribe("wx_delete_refresh")
+	defer sub.Close()
+
+	done := make(chan int)
+	go func(){
+		wm.ForceRefreshAccessToken("wx_delete_refresh")
+		close(done)
+	}()
+	<-received
+	wm.DelWechatAppByAppID("wx_delete_refresh")
+	close(release)
+	<-done
+	waitEvent(test,sub,EVENT_APP_DELETED,time.Second)
+	app.locker.RLock()
+	accessToken := app.accessToken
+	app.locker.RUnlock()
+	wm.sched.Lock()
+	_,scheduled := wm.sched.byApp[app]
+	wm.sched.Unlock()
+	if accessToken != "" || scheduled{
+		test.Error("deleted app should not be updated or rescheduled",accessToken,scheduled)
+	}
+	select{
+	case event := <-sub.C:
+		test.Error("deleted app should not publish event",event)
+	default:
+	}
+}
@@ -0,0 +1,103 @@
+package wechat
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	NONCE_STR_CHARS = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+	NONCE_STR_LEN = 16
+)
+
+//JS-SDK wx.config需要的参数
+type JsapiConfig struct {
+	AppID     string `json:"appId"`
+	Timestamp int64  `json:"timestamp"`
+	NonceStr  string `json:"nonceStr"`
+	Signature string `json:"signature"`
+}
+
+//卡券扩展字段cardExt
+type CardExt struct {
+	Code      string `json:"code"`
+	Openid    string `json:"openid"`
+	Timestamp string `json:"timestamp"`
+	NonceStr  string `json:"nonce_str"`
+	Signature string `json:"signature"`
+}
+
+//生成随机字符串
+func NewNonceStr() string{
+	buf := make([]byte,NONCE_STR_LEN)
+	if _,err := rand.Read(buf);err != nil{
+		//随机数获取失败时退化为使用时间戳
+		return strconv.FormatInt(time.Now().UnixNano(),36)
+	}
+	for i,b := range buf{
+		buf[i] = NONCE_STR_CHARS[int(b)%len(NONCE_STR_CHARS)]
+	}
+	return string(buf)
+}
+
+//JS-SDK签名，url不包含#及其后面部分
+func JsapiSignature(ticket,nonceStr string,timestamp int64,url string) string{
+	if i := strings.Index(url,"#");i >= 0{
+		url = url[:i]
+	}
+	str := "jsapi_ticket="+ticket+
+		"&noncestr="+nonceStr+
+		"&timestamp="+strconv.FormatInt(timestamp,10)+
+		"&url="+url
+	sum := sha1.Sum([]byte(str))
+	return hex.EncodeToString(sum[:])
+}
+
+//卡券签名，将所有参与签名的值按字典序排序后拼接再做sha1
+func CardSignature(values ...string) string{
+	return sortedSha1(values...)
+}
+
+func sortedSha1(values ...string) string{
+	sorted := make([]string,len(values))
+	copy(sorted,values)
+	sort.Strings(sorted)
+	sum := sha1.Sum([]byte(strings.Join(sorted,"")))
+	return hex.EncodeToString(sum[:])
+}
+
+//使用服务端维护的jsapi_ticket生成wx.config参数，ticket本身不会返回给调用方
+func (wm *WechatMan) BuildJsapiConfig(appid,token,url string) (*JsapiConfig,error){
+	ticket,_,err := wm.QueryTicket(appid,token,TICKET_TYPE_JSAPI)
+	if err != nil{
+		return nil,err
+	}
+	conf := &JsapiConfig{
+		AppID:appid,
+		Timestamp:time.Now().Unix(),
+		NonceStr:NewNonceStr(),
+	}
+	conf.Signature = JsapiSignature(ticket,conf.NonceStr,conf.Timestamp,url)
+	return conf,nil
+}
+
+//使用服务端维护的卡券api_ticket生成wx.addCard需要的cardExt，code和openid可以为空
+func (wm *WechatMan) BuildCardExt(appid,token,cardID,code,openid string) (*CardExt,error){
+	ticket,_,err := wm.QueryTicket(appid,token,TICKET_TYPE_WX_CARD)
+	if err != nil{
+		return nil,err
+	}
+	ext := &CardExt{
+		Code:code,
+		Openid:openid,
+		Timestamp:strconv.FormatInt(time.Now().Unix(),10),
+		NonceStr:NewNonceStr(),
+	}
+	ext.Signature = CardSignature(ticket,ext.Timestamp,ext.NonceStr,cardID,code,openid)
+	return ext,nil
+}
@@ -0,0 +1,29 @@
+package wechat
+
+import "testing"
+
+func TestJsapiSignature(test *testing.T){
+	//微信JS-SDK文档中的签名示例
+	ticket := "sM4AOVdWfPE4DxkXGEs8VMCPGGVi4C3VM0P37wVUCFvkVAy_90u5h9nbSlYy3-Sl-HhTdfl2fzFy1AOcHKP7qg"
+	sign := JsapiSignature(ticket,"Wm3WZYTPz0wzccnW",1414587457,"http://mp.weixin.qq.com?params=value")
+	if sign != "0f9de62fce790f9a083d5c99e95740ceb90c27ed"{
+		test.Error("jsapi signature error "+sign)
+	}
+
+	if JsapiSignature(ticket,"Wm3WZYTPz0wzccnW",1414587457,"http://mp.weixin.qq.com?params=value#hash") != sign{
+		test.Error("jsapi signature should ignore url hash")
+	}
+}
+
+func TestCardSignature(test *testing.T){
+	//sha1("123abc")
+	if CardSignature("abc","123") != "4be30d9814c6d4e9800e0d2ea9ec9fb00efa887b"{
+		test.Error("card signature should sort values")
+	}
+}
+
+func TestNewNonceStr(test *testing.T){
+	if len(NewNonceStr()) != NONCE_STR_LEN{
+		test.Error("nonce str length error")
+	}
+}
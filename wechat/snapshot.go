@@ -0,0 +1,82 @@
+package wechat
+
+import (
+	"encoding/json"
+	"github.com/dbldqt/wechatTokenServer/secure"
+	"github.com/dbldqt/wechatTokenServer/store"
+	"log"
+	"os"
+	"time"
+)
+
+//accessToken快照，设置了主密钥时加密保存，重启时加载未过期的token，避免重复请求消耗每日调用次数，同时避免使其他调用方持有的token失效
+type snapshot struct {
+	SaveTime int64          `json:"saveTime"`
+	Apps     []*store.Token `json:"apps"`
+}
+
+//设置快照文件，为空时不保存快照
+func (wm *WechatMan) SetSnapshotFile(file string){
+	wm.Lock()
+	wm.snapshotFile = file
+	wm.Unlock()
+}
+
+//保存所有应用的accessToken到快照文件，先写临时文件再重命名，避免写入中途退出导致快照损坏
+func (wm *WechatMan) saveSnapshot(){
+	wm.RLock()
+	file := wm.snapshotFile
+	if file == ""{
+		wm.RUnlock()
+		return
+	}
+	snap := snapshot{
+		SaveTime:time.Now().Unix(),
+		Apps:make([]*store.Token,0,len(wm.apps)),
+	}
+	for _,app := range wm.apps{
+		app.locker.RLock()
+		if app.accessToken != ""{
+			snap.Apps = append(snap.Apps,app.storeToken())
+		}
+		app.locker.RUnlock()
+	}
+	wm.RUnlock()
+
+	content,err := json.Marshal(snap)
+	if err != nil{
+		log.Println("marshal snapshot error "+err.Error())
+		return
+	}
+	tmpFile := file+".tmp"
+	if err := secure.WriteFile(tmpFile,content,0600);err != nil{
+		log.Println("write snapshot error "+err.Error())
+		return
+	}
+	if err := os.Rename(tmpFile,file);err != nil{
+		log.Println("rename snapshot error "+err.Error())
+	}
+}
+
+//加载快照中仍然有效的accessToken，需要在Run之前调用，接近过期的token在Run后立即刷新
+func (wm *WechatMan) LoadSnapshot() error{
+	wm.RLock()
+	file := wm.snapshotFile
+	wm.RUnlock()
+	if file == ""{
+		return nil
+	}
+	content,err := secure.ReadFile(file)
+	if err != nil{
+		if os.IsNotExist(err){
+			return nil
+		}
+		return err
+	}
+	snap := snapshot{}
+	if err := json.Unmarshal(content,&snap);err != nil{
+		return err
+	}
+	wm.restoreTokens("snapshot",snap.Apps...)
+	return nil
+}
@@ -0,0 +1,38 @@
+package wechat
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(test *testing.T){
+	dir,err := ioutil.TempDir("","wechatman")
+	if err != nil{
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := &WechatConfig{AppID:"wx_snapshot",AppSecret:"secret",Token:"token"}
+	wm := &WechatMan{apps:[]*WechatApp{NewWechatApp(conf,600)}}
+	wm.SetSnapshotFile(filepath.Join(dir,"snapshot"))
+	wm.apps[0].accessToken = "snapshot_token"
+	wm.apps[0].updateTime = time.Now()
+	wm.apps[0].duration = 6600*time.Second
+	wm.saveSnapshot()
+
+	loaded := &WechatMan{apps:[]*WechatApp{NewWechatApp(conf,600)}}
+	loaded.SetSnapshotFile(filepath.Join(dir,"snapshot"))
+	if err := loaded.LoadSnapshot();err != nil{
+		test.Fatal("load snapshot error "+err.Error())
+	}
+	token,expireAt,err := loaded.QueryAccessToken("wx_snapshot","token")
+	if err != nil || token != "snapshot_token"{
+		test.Error("snapshot token not loaded")
+	}
+	if expireAt != wm.apps[0].updateTime.Add(6600*time.Second).Unix(){
+		test.Error("snapshot expire time error")
+	}
+}
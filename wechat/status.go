@@ -0,0 +1,81 @@
+package wechat
+
+import (
+	"github.com/dbldqt/wechatTokenServer/store"
+	"sort"
+)
+
+//应用状态，不包含secret和accessToken，供管理接口查看
+type AppStatus struct {
+	AppID          string   `json:"appid"`
+	ComponentAppID string   `json:"componentAppid,omitempty"`
+	Type           string   `json:"type"`
+	Provider       string   `json:"provider"`
+	StableToken    bool     `json:"stableToken"`
+	HasToken       bool     `json:"hasToken"`
+	UpdateTime     int64    `json:"updateTime"`
+	ExpireAt       int64    `json:"expireAt"`
+	Deleted        bool     `json:"deleted"`
+	Tickets        []string `json:"tickets"`
+}
+
+//应用的key，用于订阅事件
+func (status *AppStatus) Key() string{
+	return store.TokenKey(status.ComponentAppID,status.AppID)
+}
+
+//调用方需要持有app.locker
+func (wa *WechatApp) status() *AppStatus{
+	provider := wa.WechatConfig.Provider
+	if provider == ""{
+		provider = PROVIDER_WECHAT
+	}
+	status := &AppStatus{
+		AppID:wa.WechatConfig.AppID,
+		ComponentAppID:wa.WechatConfig.ComponentAppID,
+		Type:wa.WechatConfig.Type,
+		Provider:provider,
+		StableToken:wa.WechatConfig.StableToken,
+		HasToken:wa.accessToken != "",
+		Deleted:wa.deleted,
+		Tickets:make([]string,0,len(wa.tickets)),
+	}
+	if status.HasToken{
+		status.UpdateTime = wa.updateTime.Unix()
+		status.ExpireAt = wa.updateTime.Add(wa.duration).Unix()
+	}
+	for ticketType := range wa.tickets{
+		status.Tickets = append(status.Tickets,ticketType)
+	}
+	sort.Strings(status.Tickets)
+	return status
+}
+
+//查询单个应用的状态，appid和token需要匹配
+func (wm *WechatMan) QueryAppStatus(appid,token string) (*AppStatus,error){
+	wm.RLock()
+	defer wm.RUnlock()
+	for _,app := range wm.apps{
+		app.locker.RLock()
+		if app.WechatConfig.AppID == appid && app.WechatConfig.Token == token{
+			status := app.status()
+			app.locker.RUnlock()
+			return status,nil
+		}
+		app.locker.RUnlock()
+	}
+	return nil,ErrAppNotFound
+}
+
+//列出所有应用的状态，包括第三方平台的授权方
+func (wm *WechatMan) ListAppStatus() []*AppStatus{
+	wm.RLock()
+	defer wm.RUnlock()
+	list := make([]*AppStatus,0,len(wm.apps))
+	for _,app := range wm.apps{
+		app.locker.RLock()
+		list = append(list,app.status())
+		app.locker.RUnlock()
+	}
+	return list
+}
@@ -0,0 +1,60 @@
+package wechat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueryAppStatus(test *testing.T){
+	conf := &WechatConfig{AppID:"wx_status",AppSecret:"secret",Token:"token",JsapiTicket:true,CardTicket:true}
+	wm := &WechatMan{apps:[]*WechatApp{NewWechatApp(conf,600)}}
+	if _,err := wm.QueryAppStatus("wx_status","wrong");err != ErrAppNotFound{
+		test.Error("wrong token should not found app")
+	}
+	status,err := wm.QueryAppStatus("wx_status","token")
+	if err != nil{
+		test.Fatal(err)
+	}
+	if status.HasToken || status.ExpireAt != 0 || status.Provider != PROVIDER_WECHAT{
+		test.Error("status before refresh error",status)
+	}
+	if len(status.Tickets) != 2 || status.Tickets[0] != TICKET_TYPE_JSAPI{
+		test.Error("status tickets error",status.Tickets)
+	}
+
+	app := wm.apps[0]
+	app.accessToken = "status_token"
+	app.updateTime = time.Now()
+	app.duration = 6600*time.Second
+	list := wm.ListAppStatus()
+	if len(list) != 1 || !list[0].HasToken || list[0].ExpireAt != app.updateTime.Add(app.duration).Unix(){
+		test.Error("list status error",list)
+	}
+}
+
+func TestBatchQueryAccessToken(test *testing.T){
+	ready := NewWechatApp(&WechatConfig{AppID:"wx_ready",AppSecret:"secret",Token:"token"},600)
+	ready.accessToken = "ready_token"
+	ready.updateTime = time.Now()
+	ready.duration = 6600*time.Second
+	waiting := NewWechatApp(&WechatConfig{AppID:"wx_waiting",AppSecret:"secret",Token:"token"},600)
+	wm := &WechatMan{apps:[]*WechatApp{ready,waiting}}
+
+	results := wm.BatchQueryAccessToken(
+		&TokenQuery{AppID:"wx_ready",Token:"token"},
+		&TokenQuery{AppID:"wx_waiting",Token:"token"},
+		&TokenQuery{AppID:"wx_ready",Token:"wrong"},
+	)
+	if len(results) != 3{
+		test.Fatal("batch result length error")
+	}
+	if results[0].Err != nil || results[0].AccessToken != "ready_token"{
+		test.Error("ready app should return token",results[0])
+	}
+	if results[1].Err != ErrTokenNotReady{
+		test.Error("waiting app should not ready",results[1].Err)
+	}
+	if results[2].Err != ErrAppNotFound || results[2].AccessToken != ""{
+		test.Error("wrong token should not found app",results[2].Err)
+	}
+}
@@ -0,0 +1,113 @@
+package wechat
+
+import (
+	"github.com/dbldqt/wechatTokenServer/store"
+	"log"
+	"time"
+)
+
+//调用方需持有wa.locker
+func (wa *WechatApp) storeToken() *store.Token{
+	return &store.Token{
+		AppID:wa.WechatConfig.AppID,
+		ComponentAppID:wa.WechatConfig.ComponentAppID,
+		AccessToken:wa.accessToken,
+		UpdateTime:wa.updateTime.Unix(),
+		//duration已经减去提前更新时间
+		ExpireAt:wa.updateTime.Add(wa.duration+time.Duration(wa.refreshAhead())*time.Second).Unix(),
+	}
+}
+
+//设置accessToken存储，WechatMan每次刷新accessToken后写入
+func (wm *WechatMan) SetTokenStore(ts store.TokenStore){
+	wm.Lock()
+	wm.store = ts
+	wm.Unlock()
+}
+
+func (wm *WechatMan) GetTokenStore() store.TokenStore{
+	wm.RLock()
+	defer wm.RUnlock()
+	return wm.store
+}
+
+//加载存储中仍然有效的accessToken，需要在Run之前调用
+func (wm *WechatMan) LoadTokenStore() error{
+	ts := wm.GetTokenStore()
+	if ts == nil{
+		return nil
+	}
+	tokens,err := ts.List()
+	if err != nil{
+		return err
+	}
+	wm.restoreTokens("token store",tokens...)
+	return nil
+}
+
+//使用保存的accessToken更新应用，只恢复未过期且比当前accessToken新的token，返回有更新的应用
+func (wm *WechatMan) restoreTokens(from string,tokens ...*store.Token) []*WechatApp{
+	updated := make([]*WechatApp,0)
+	wm.RLock()
+	defer wm.RUnlock()
+	for _,token := range tokens{
+		if token.AccessToken == "" || token.Expired(){
+			continue
+		}
+		updateTime := time.Unix(token.UpdateTime,0)
+		expireAt := time.Unix(token.ExpireAt,0)
+		for _,app := range wm.apps{
+			if app.WechatConfig.AppID != token.AppID || app.WechatConfig.ComponentAppID != token.ComponentAppID{
+				continue
+			}
+			app.locker.Lock()
+			if app.accessToken == "" || token.UpdateTime > app.updateTime.Unix(){
+				app.accessToken = token.AccessToken
+				app.updateTime = updateTime
+				//按照当前的提前更新时间计算下次更新
+				app.duration = expireAt.Sub(updateTime)-time.Duration(app.refreshAhead())*time.Second
+				updated = append(updated,app)
+				log.Println(app.WechatConfig.AppID+" load accesstoken from "+from)
+				eventBus.Publish(app.tokenEvent(from))
+			}
+			app.locker.Unlock()
+		}
+	}
+	wm.reschedule(updated...)
+	return updated
+}
+
+//刷新accessToken后写入存储及快照
+func (wm *WechatMan) saveTokens(apps ...*WechatApp){
+	ts := wm.GetTokenStore()
+	if ts != nil{
+		for _,app := range apps{
+			app.locker.RLock()
+			token := app.storeToken()
+			app.locker.RUnlock()
+			if token.AccessToken == ""{
+				continue
+			}
+			if err := ts.Put(token);err != nil{
+				log.Println(token.Key()+" save token to store error "+err.Error())
+			}
+		}
+	}
+	wm.saveSnapshot()
+}
+
+//应用删除后从存储中移除，同时通知订阅者，授权方只删除所属第三方平台下的accessToken
+func (wm *WechatMan) deleteTokens(apps ...*WechatApp){
+	for _,app := range apps{
+		eventBus.Publish(&Event{Type:EVENT_APP_DELETED,AppID:app.WechatConfig.AppID,ComponentAppID:app.WechatConfig.ComponentAppID})
+	}
+	ts := wm.GetTokenStore()
+	if ts == nil{
+		return
+	}
+	for _,app := range apps{
+		if err := ts.Delete(app.WechatConfig.ComponentAppID,app.WechatConfig.AppID);err != nil{
+			log.Println(app.WechatConfig.AppID+" delete token from store error "+err.Error())
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package wechat
+
+import (
+	"github.com/dbldqt/wechatTokenServer/store"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//授权方与直接配置的同一appid的应用分别保存，取消授权不影响直接配置的应用
+func TestRemoveAuthorizerToken(test *testing.T){
+	dir,err := ioutil.TempDir("","wechatman")
+	if err != nil{
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	component := NewWechatApp(&WechatConfig{AppID:"wx_store_component",AppSecret:"secret",Token:"token",Type:APP_TYPE_COMPONENT,
+		VerifyTicketFile:filepath.Join(dir,"ticket"),AuthorizerFile:filepath.Join(dir,"authorizers")},600)
+	direct := NewWechatApp(&WechatConfig{AppID:"wx_store_shared",AppSecret:"secret",Token:"token"},600)
+	authorizer := component.newAuthorizerApp("wx_store_shared")
+	component.authorizers["wx_store_shared"] = "refresh_token"
+	wm := &WechatMan{apps:[]*WechatApp{component,direct,authorizer},store:store.NewMemoryStore()}
+	direct.accessToken = "direct_token"
+	authorizer.accessToken = "authorizer_token"
+	for _,app := range []*WechatApp{direct,authorizer}{
+		app.updateTime = time.Now()
+		app.duration = time.Hour
+	}
+	wm.saveTokens(direct,authorizer)
+	if tokens,_ := wm.store.List();len(tokens) != 2{
+		test.Fatal("authorizer should not overwrite direct app token",len(tokens))
+	}
+
+	if err := wm.RemoveAuthorizer("wx_store_component","wx_store_shared");err != nil{
+		test.Fatal(err)
+	}
+	if token,err := wm.store.Get("","wx_store_shared");err != nil || token.AccessToken != direct.accessToken{
+		test.Error("direct app token should be kept",err)
+	}
+	if _,err := wm.store.Get("wx_store_component","wx_store_shared");err != store.ErrNotFound{
+		test.Error("authorizer token should be deleted",err)
+	}
+}
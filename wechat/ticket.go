@@ -0,0 +1,157 @@
+package wechat
+
+import (
+	"fmt"
+	"github.com/tidwall/gjson"
+	"github.com/valyala/fasthttp"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	TICKET_API = "/cgi-bin/ticket/getticket?access_token=%s&type=%s"
+	TICKET_TYPE_JSAPI = "jsapi"
+	TICKET_TYPE_WX_CARD = "wx_card"
+)
+
+//微信ticket，使用accessToken获取，有效期和accessToken一致
+type WechatTicket struct {
+	ticketType string
+	ticket     string
+	updateTime time.Time
+	duration   time.Duration
+}
+
+func (wt *WechatTicket) GetTicket() string{
+	return wt.ticket
+}
+
+func (wt *WechatTicket) GetUpdateTime() time.Time{
+	return wt.updateTime
+}
+
+func (wt *WechatTicket) GetDuration() time.Duration{
+	return wt.duration
+}
+
+//ticket为空或者已经到达更新时间
+func (wt *WechatTicket) needUpdate() bool{
+	return wt.ticket == "" || time.Since(wt.updateTime) >= wt.duration
+}
+
+//根据配置初始化需要维护的ticket
+func newWechatTickets(wc *WechatConfig) map[string]*WechatTicket{
+	tickets := make(map[string]*WechatTicket)
+	//企业微信的ticket接口不同，暂不维护
+	if wc.IsWecom(){
+		return tickets
+	}
+	if wc.JsapiTicket{
+		tickets[TICKET_TYPE_JSAPI] = &WechatTicket{ticketType:TICKET_TYPE_JSAPI}
+	}
+	if wc.CardTicket{
+		tickets[TICKET_TYPE_WX_CARD] = &WechatTicket{ticketType:TICKET_TYPE_WX_CARD}
+	}
+	return tickets
+}
+
+func (wa *WechatApp) GetTicket(ticketType string) *WechatTicket{
+	return wa.tickets[ticketType]
+}
+
+//使用当前的accessToken更新指定类型的ticket
+func (wa *WechatApp) UpdateTicket(ticketType string,wg *sync.WaitGroup){
+	defer wg.Done()
+	wa.updateTicket(ticketType)
+}
+
+func (wa *WechatApp) updateTicket(ticketType string){
+	wa.locker.RLock()
+	accessToken := wa.accessToken
+	apiBase := wa.WechatConfig.apiBase()
+	provider := wa.WechatConfig.Provider
+	_,ok := wa.tickets[ticketType]
+	wa.locker.RUnlock()
+	if !ok || accessToken == ""{
+		return
+	}
+
+	_,resp,err := fasthttp.Get(nil,apiBase+fmt.Sprintf(TICKET_API,accessToken,ticketType))
+	if err != nil{
+		log.Println(wa.WechatConfig.AppID+" request "+ticketType+" ticket error "+err.Error())
+		return
+	}
+	nowTime := time.Now()
+	jre := gjson.Parse(string(resp))
+	if !jre.Get("ticket").Exists() || jre.Get("ticket").String() == ""{
+		log.Println("request ticket error"+string(resp))
+		errcode := int(jre.Get("errcode").Int())
+		errmsg := GetProviderErrorMsg(provider,errcode)
+		if errmsg == ERROR_UNKONWN{
+			errmsg = jre.Get("errmsg").String()
+		}
+		log.Println(strconv.Itoa(errcode)+":"+errmsg)
+		return
+	}
+
+	wa.locker.Lock()
+	defer wa.locker.Unlock()
+	//请求期间重新加载配置可能已经取消维护该ticket
+	ticket,ok := wa.tickets[ticketType]
+	if !ok{
+		return
+	}
+	ticket.ticket = jre.Get("ticket").String()
+	ticket.updateTime = nowTime
+	num,err := strconv.Atoi(jre.Get("expires_in").String())
+	if err == nil{
+		//和accessToken一样提前一定时间去更新
+		ticket.duration = time.Second*time.Duration(num-wa.aheadTime)
+	}else{
+		log.Println("prase ticket expire error "+err.Error())
+		ticket.duration = time.Nanosecond
+	}
+	log.Println(wa.WechatConfig.AppID+" "+ticketType+" ticket:"+ticket.ticket)
+}
+
+//accessToken更新后，所有的ticket需要使用新的accessToken重新获取
+func (wa *WechatApp) updateAllTickets(){
+	wa.locker.RLock()
+	ticketTypes := make([]string,0,len(wa.tickets))
+	for ticketType := range wa.tickets{
+		ticketTypes = append(ticketTypes,ticketType)
+	}
+	wa.locker.RUnlock()
+	for _,ticketType := range ticketTypes{
+		wa.updateTicket(ticketType)
+	}
+}
+
+func (wm *WechatMan) QueryTicket(appid,token,ticketType string) (string,int64,error){
+	var ticket string
+	var expireAt int64
+	var err error = ErrAppNotFound
+	wm.RLock()
+	for _,app := range wm.apps{
+		app.locker.RLock()
+		if app.WechatConfig.AppID == appid && app.WechatConfig.Token == token{
+			err = nil
+			if wt,ok := app.tickets[ticketType];ok{
+				ticket = wt.ticket
+				expireAt = wt.updateTime.Add(wt.duration).Unix()
+				if app.deleted{
+					err = ErrAppDeleted
+				}
+			}
+		}
+		app.locker.RUnlock()
+	}
+	wm.RUnlock()
+	if ticket == "" && err != ErrAppNotFound{
+		err = ErrTicketNotReady
+		expireAt = 0
+	}
+	return ticket,expireAt,err
+}
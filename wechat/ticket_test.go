@@ -0,0 +1,50 @@
+package wechat
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUpdateTicket(test *testing.T){
+	fake := newFakeWechat(test,"wx_ticket")
+	defer fake.Close()
+	wm := newTestWechatMan(600,60,&WechatConfig{AppID:"wx_ticket",AppSecret:"secret",Token:"token",JsapiTicket:true,CardTicket:true,ApiBaseURL:fake.URL()})
+	app := wm.apps[0]
+	if _,ok := app.refresh(false);!ok{
+		test.Fatal("refresh accesstoken failed")
+	}
+	jsapi,expireAt,err := wm.QueryTicket("wx_ticket","token",TICKET_TYPE_JSAPI)
+	if err != nil || jsapi == "" || expireAt-time.Now().Unix() > 7200-600{
+		test.Error("jsapi ticket error",jsapi,expireAt,err)
+	}
+	if card,_,err := wm.QueryTicket("wx_ticket","token",TICKET_TYPE_WX_CARD);err != nil || card == "" || card == jsapi{
+		test.Error("card ticket error",card,err)
+	}
+
+	//accessToken失效时getticket返回40001，保留原来的ticket
+	fake.Invalidate("wx_ticket")
+	app.updateTicket(TICKET_TYPE_JSAPI)
+	if ticket,_,err := wm.QueryTicket("wx_ticket","token",TICKET_TYPE_JSAPI);err != nil || ticket != jsapi{
+		test.Error("failed update should keep ticket",ticket,err)
+	}
+}
+
+//请求期间取消维护ticket时忽略返回的ticket
+func TestUpdateRemovedTicket(test *testing.T){
+	var app *WechatApp
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,r *http.Request){
+		app.locker.Lock()
+		app.tickets = newWechatTickets(&WechatConfig{AppID:"wx_ticket_removed"})
+		app.locker.Unlock()
+		w.Write([]byte(`{"errcode":0,"errmsg":"ok","ticket":"removed_ticket","expires_in":7200}`))
+	}))
+	defer server.Close()
+	app = NewWechatApp(&WechatConfig{AppID:"wx_ticket_removed",JsapiTicket:true,ApiBaseURL:server.URL},600)
+	app.accessToken = "token"
+	app.updateTicket(TICKET_TYPE_JSAPI)
+	if app.GetTicket(TICKET_TYPE_JSAPI) != nil{
+		test.Error("removed ticket should not be restored")
+	}
+}
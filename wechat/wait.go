@@ -0,0 +1,59 @@
+package wechat
+
+import (
+	"time"
+)
+
+//应用的accessToken是否比调用方持有的新，current为调用方持有的accessToken，updatedAfter为其更新时间
+func (wm *WechatMan) newerAccessToken(appid,token,current string,updatedAfter int64) (*Event,bool,error){
+	wm.RLock()
+	defer wm.RUnlock()
+	for _,app := range wm.apps{
+		app.locker.RLock()
+		if app.WechatConfig.AppID == appid && app.WechatConfig.Token == token{
+			event := app.tokenEvent("")
+			deleted := app.deleted
+			app.locker.RUnlock()
+			if deleted{
+				return event,false,ErrAppDeleted
+			}
+			newer := event.AccessToken != "" && event.AccessToken != current && event.UpdateTime > updatedAfter
+			return event,newer,nil
+		}
+		app.locker.RUnlock()
+	}
+	return nil,false,ErrAppNotFound
+}
+
+//等待比调用方持有的更新的accessToken，超时返回当前accessToken和ErrWaitTimeout
+//先订阅再检查当前状态，避免检查和等待之间的更新被错过
+func (wm *WechatMan) WaitAccessToken(appid,token,current string,updatedAfter int64,timeout time.Duration) (*Event,error){
+	//按照通过token校验的应用订阅，不接收其他第三方平台下同一个appid的事件
+	status,err := wm.QueryAppStatus(appid,token)
+	if err != nil{
+		return nil,err
+	}
+	key := status.Key()
+	sub := eventBus.Subscribe(key)
+	//sub可能重新订阅，关闭时使用最新的订阅
+	defer func(){
+		sub.Close()
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for{
+		event,newer,err := wm.newerAccessToken(appid,token,current,updatedAfter)
+		if err != nil || newer{
+			return event,err
+		}
+		select{
+		case _,ok := <-sub.C:
+			//订阅被关闭时重新订阅，之后重新检查状态
+			if !ok{
+				sub = eventBus.Subscribe(key)
+			}
+		case <-timer.C:
+			return event,ErrWaitTimeout
+		}
+	}
+}
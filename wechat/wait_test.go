@@ -0,0 +1,40 @@
+package wechat
+
+import (
+	"github.com/dbldqt/wechatTokenServer/store"
+	"testing"
+	"time"
+)
+
+func TestWaitAccessToken(test *testing.T){
+	app := NewWechatApp(&WechatConfig{AppID:"wx_wait",AppSecret:"secret",Token:"token"},600)
+	app.accessToken = "old_token"
+	app.updateTime = time.Now().Add(-time.Minute)
+	app.duration = 6600*time.Second
+	wm := &WechatMan{apps:[]*WechatApp{app}}
+
+	if event,err := wm.WaitAccessToken("wx_wait","token","stale_token",0,time.Second);err != nil || event.AccessToken != "old_token"{
+		test.Error("newer token should return immediately",err)
+	}
+	if _,err := wm.WaitAccessToken("wx_wait","wrong","old_token",0,time.Second);err != ErrAppNotFound{
+		test.Error("wrong token should not found app",err)
+	}
+	if event,err := wm.WaitAccessToken("wx_wait","token","old_token",0,50*time.Millisecond);err != ErrWaitTimeout || event.AccessToken != "old_token"{
+		test.Error("wait should timeout with current token",err)
+	}
+
+	go func(){
+		time.Sleep(50*time.Millisecond)
+		now := time.Now()
+		wm.restoreTokens("test",&store.Token{
+			AppID:"wx_wait",
+			AccessToken:"new_token",
+			UpdateTime:now.Unix(),
+			ExpireAt:now.Add(7200*time.Second).Unix(),
+		})
+	}()
+	event,err := wm.WaitAccessToken("wx_wait","token","",app.updateTime.Unix(),5*time.Second)
+	if err != nil || event.AccessToken != "new_token"{
+		test.Error("wait should return new token",err,event)
+	}
+}
@@ -3,17 +3,26 @@ package wechat
 import (
 	"errors"
 	"fmt"
-	"github.com/dbldqt/util"
+	"github.com/dbldqt/wechatTokenServer/store"
 	"github.com/tidwall/gjson"
 	"github.com/valyala/fasthttp"
 	"log"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
 const (
-	ACCESS_TOKEN_API  = "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=%s&secret=%s"
+	//接口路径，请求时拼接在应用的ApiBaseURL之后
+	ACCESS_TOKEN_API  = "/cgi-bin/token?grant_type=client_credential&appid=%s&secret=%s"
+	STABLE_TOKEN_API = "/cgi-bin/stable_token"
+	WECOM_ACCESS_TOKEN_API = "/cgi-bin/gettoken?corpid=%s&corpsecret=%s"
+	WECHAT_API_BASE = "https://api.weixin.qq.com"
+	WECOM_API_BASE = "https://qyapi.weixin.qq.com"
+	PROVIDER_WECHAT = "wechat"  //微信公众号、小程序、开放平台
+	PROVIDER_WECOM = "wecom"    //企业微信
+	STABLE_TOKEN_ROTATE_WINDOW = 300 //stable_token普通模式在accessToken最后5分钟内调用才会返回新的accessToken，单位秒
 )
 //微信配置信息
 type WechatConfig struct {
@@ -21,6 +30,30 @@ type WechatConfig struct {
 	AppSecret string      //微信appsecret
 	Token string          //查询校验token
 	NotifyUrl []string	  //accessToken更新后的通知url
+	JsapiTicket bool      //是否同时维护jsapi_ticket
+	CardTicket bool       //是否同时维护卡券api_ticket
+	Type string           //应用类型，为空表示公众号或小程序，component表示开放平台第三方平台
+	MsgToken string       //第三方平台消息校验token
+	EncodingAESKey string //第三方平台消息加解密key
+	VerifyTicketFile string //第三方平台component_verify_ticket保存文件
+	AuthorizerFile string //第三方平台授权方refresh_token保存文件
+	ComponentAppID string `toml:"-"` //授权方所属的第三方平台appid，授权方由第三方平台动态维护
+	Provider string       //服务提供方，为空或wechat表示微信，wecom表示企业微信
+	CorpID string         //企业微信corpid，为空时使用AppID，同一企业的多个应用可以使用不同的AppID区分
+	StableToken bool      //是否使用stable_token接口，获取新token不会使其他调用方持有的token失效
+	ApiBaseURL string     //微信接口地址，为空时使用官方地址，可以配置为出口代理或者测试用的模拟服务
+}
+//批量查询的appid和token
+type TokenQuery struct {
+	AppID string `json:"appid"`
+	Token string `json:"token"`
+}
+//批量查询结果，Err为该appid的查询错误
+type TokenQueryResult struct {
+	AppID       string
+	AccessToken string
+	ExpireAt    int64
+	Err         error
 }
 //定义微信应用，每个微信配置看做不同的应用
 type WechatApp struct {
@@ -32,6 +65,13 @@ type WechatApp struct {
 	aheadTime int
 	deleted bool
 	needUpdate bool
+	tickets map[string]*WechatTicket  //该应用需要维护的ticket，key为ticket类型
+	verifyTicket string  //第三方平台的component_verify_ticket
+	authorizers map[string]string  //第三方平台的授权方，key为授权方appid，value为authorizer_refresh_token
+	component *WechatApp //授权方所属的第三方平台
+	failures int  //连续刷新失败的次数
+	permanentFailure bool //最近一次失败的errcode不能重试
+	retryTimer *time.Timer //等待中的重试
 }
 
 func (wa *WechatApp)GetAccessToken() string{
@@ -50,61 +90,173 @@ func (wa *WechatApp)GetDuration() time.Duration{
 	return wa.duration
 }
 
+func (wc *WechatConfig) IsWecom() bool{
+	return wc.Provider == PROVIDER_WECOM
+}
+
+//应用使用的微信接口地址，企业微信和微信的默认地址不同
+func (wc *WechatConfig) apiBase() string{
+	if wc.ApiBaseURL != ""{
+		return strings.TrimRight(wc.ApiBaseURL,"/")
+	}
+	if wc.IsWecom(){
+		return WECOM_API_BASE
+	}
+	return WECHAT_API_BASE
+}
+
+func (wc *WechatConfig) corpID() string{
+	if wc.CorpID != ""{
+		return wc.CorpID
+	}
+	return wc.AppID
+}
+
+//不同类型的应用使用不同的接口获取accessToken，forceRefresh仅对stable_token接口有效
+func (wa *WechatApp) requestAccessToken(forceRefresh bool) ([]byte,string,error){
+	if wa.WechatConfig.IsWecom(){
+		_,resp,err := fasthttp.Get(nil,wa.WechatConfig.apiBase()+fmt.Sprintf(WECOM_ACCESS_TOKEN_API,wa.WechatConfig.corpID(),wa.WechatConfig.AppSecret))
+		return resp,"access_token",err
+	}
+	if wa.IsComponent(){
+		resp,err := wa.requestComponentAccessToken()
+		return resp,"component_access_token",err
+	}
+	if wa.IsAuthorizer(){
+		resp,err := wa.requestAuthorizerAccessToken()
+		return resp,"authorizer_access_token",err
+	}
+	if wa.WechatConfig.StableToken{
+		resp,err := postJson(wa.WechatConfig.apiBase()+STABLE_TOKEN_API,map[string]interface{}{
+			"grant_type":"client_credential",
+			"appid":wa.WechatConfig.AppID,
+			"secret":wa.WechatConfig.AppSecret,
+			"force_refresh":forceRefresh,
+		})
+		return resp,"access_token",err
+	}
+	_,resp,err := fasthttp.Get(nil,wa.WechatConfig.apiBase()+fmt.Sprintf(ACCESS_TOKEN_API,wa.WechatConfig.AppID,wa.WechatConfig.AppSecret))
+	return resp,"access_token",err
+}
+
+//提前更新accessToken的时间，stable_token不超过STABLE_TOKEN_ROTATE_WINDOW，
+//保证到期刷新时使用force_refresh=false也能获取新的accessToken，调用方需要持有wa的锁
+func (wa *WechatApp) refreshAhead() int{
+	if wa.WechatConfig.StableToken && wa.aheadTime > STABLE_TOKEN_ROTATE_WINDOW{
+		return STABLE_TOKEN_ROTATE_WINDOW
+	}
+	return wa.aheadTime
+}
+
+//是否具备请求accessToken的条件，第三方平台需要先收到component_verify_ticket
+func (wa *WechatApp) canRequestAccessToken() bool{
+	if wa.IsComponent(){
+		return wa.WechatConfig.AppSecret != "" && wa.verifyTicket != ""
+	}
+	if wa.IsAuthorizer(){
+		return wa.componentReady()
+	}
+	return wa.WechatConfig.AppSecret != ""
+}
+
 func (wa *WechatApp) UpdateAccessToken(wg *sync.WaitGroup){
-	_,resp,error := fasthttp.Get(nil,fmt.Sprintf(ACCESS_TOKEN_API,wa.WechatConfig.AppID,wa.WechatConfig.AppSecret))
+	defer wg.Done()
+	wa.refresh(false)
+}
+
+//强制刷新accessToken，使用stable_token接口时会携带force_refresh=true，只用于/update接口，定时刷新使用force_refresh=false
+func (wa *WechatApp) ForceUpdateAccessToken(wg *sync.WaitGroup){
+	defer wg.Done()
+	wa.refresh(true)
+}
+
+//请求并更新accessToken，失败时返回errcode，网络错误时errcode为-1
+func (wa *WechatApp) refresh(forceRefresh bool) (int,bool){
+	wa.locker.RLock()
+	deleted := wa.deleted
+	wa.locker.RUnlock()
+	if deleted{
+		return 0,false
+	}
+	resp,tokenKey,error := wa.requestAccessToken(forceRefresh)
 	if error != nil{
-		return
+		log.Println(wa.WechatConfig.AppID+" request accesstoken error "+error.Error())
+		wa.refreshFailed(-1,error.Error())
+		return -1,false
 	}
 	nowTime := time.Now()
 	jre := gjson.Parse(string(resp))
-	if jre.Get("access_token").Exists(){
+	if jre.Get(tokenKey).Exists(){
 		wa.locker.Lock()
+		//请求期间应用已被删除，丢弃新的accessToken
+		if wa.deleted{
+			wa.locker.Unlock()
+			return 0,false
+		}
 		wa.needUpdate = false
-		wa.accessToken = jre.Get("access_token").String()
+		wa.failures = 0
+		wa.permanentFailure = false
+		accessToken := jre.Get(tokenKey).String()
+		rotated := accessToken != wa.accessToken
+		wa.accessToken = accessToken
 		log.Println(wa.WechatConfig.AppID+":"+wa.accessToken)
 		num,err := strconv.Atoi(jre.Get("expires_in").String())
 		if err == nil{
-			num = num-wa.aheadTime  //提前一定时间去更新
+			num = num-wa.refreshAhead()  //提前一定时间去更新
 			wa.duration = time.Second*time.Duration(num)
 			wa.updateTime = nowTime
 		}else{
 			log.Println("prase accesstoken expire error "+err.Error())
-			num = 0
 			wa.duration = time.Nanosecond
 			wa.updateTime = nowTime
 		}
-		for _,url := range wa.WechatConfig.NotifyUrl{
-			if url != ""{
-				go func(){
-					resp,err := util.PostFields(url,map[string]string{
-						"accessToken":wa.accessToken,
-						"updateTime":strconv.FormatInt(wa.updateTime.Unix(),10),
-						"expires_in":strconv.Itoa(num),
-					})
-					if err != nil{
-						log.Println(url+" notify accessToken update err url:"+err.Error())
-					}
-					log.Println(url+" notify url response "+string(resp))
-				}()
-			}
+		//授权方每次刷新可能返回新的refresh_token，持有授权方的锁写入，取消授权时已标记删除的不会被写回
+		if wa.IsAuthorizer(){
+			wa.component.setAuthorizerRefreshToken(wa.WechatConfig.AppID,jre.Get("authorizer_refresh_token").String())
+		}
+		//stable_token返回同一个accessToken时按照剩余有效期更新到期时间，不推送事件，ticket仍然有效
+		if !rotated{
+			wa.locker.Unlock()
+			return 0,true
 		}
+		//NotifyUrl通知和订阅者推送都通过事件总线
+		event := wa.tokenEvent(EVENT_SOURCE_REFRESH)
 		wa.locker.Unlock()
+		eventBus.Publish(event)
+		//accessToken变化后，ticket需要重新获取
+		wa.updateAllTickets()
 	}else{
 		log.Println("request accesstoken error"+string(resp))
 		errcode := int(jre.Get("errcode").Int())
-		errmsg := GetErrorMsg(errcode)
+		errmsg := GetProviderErrorMsg(wa.WechatConfig.Provider,errcode)
 		if errmsg == ERROR_UNKONWN{
 			errmsg = jre.Get("errmsg").String()
 		}
 		log.Println(strconv.Itoa(errcode)+":"+errmsg)
+		wa.refreshFailed(errcode,errmsg)
+		return errcode,false
 	}
-	wg.Done()
+	return 0,true
+}
+
+//记录失败次数并推送refresh_failed事件
+func (wa *WechatApp) refreshFailed(errcode int,errmsg string){
+	wa.locker.Lock()
+	wa.failures++
+	wa.permanentFailure = !IsRetryableErrcode(wa.WechatConfig.Provider,errcode)
+	wa.locker.Unlock()
+	wa.publishRefreshFailed(errcode,errmsg)
 }
 
 func NewWechatApp(wc *WechatConfig,aheadTime int) *WechatApp{
 	wa := &WechatApp{
 		WechatConfig: wc,
 		aheadTime:aheadTime,
+		tickets:newWechatTickets(wc),
+	}
+	if wa.IsComponent(){
+		wa.loadVerifyTicket()
+		wa.loadAuthorizers()
 	}
 	return wa
 }
@@ -113,131 +265,115 @@ type WechatMan struct{
 	sync.RWMutex
 	apps []*WechatApp
 	isRuning bool             //标志wechatApp是否已经运行
-	loopStopChan chan int     //控制loopAccessToken结束
+	loopStopChan chan int     //控制调度协程结束
 	aheadTime int
-	loopTime int
+	loopTime int              //暂时不能刷新的应用再次检查的间隔
+	sched *scheduler          //按照到期时间调度每个应用的刷新
+	snapshotFile string       //accessToken快照文件
+	store store.TokenStore    //accessToken存储，每次刷新后写入
+	leader LeaderChecker      //多实例部署时判断当前节点是否为leader
+	retry RetryPolicy         //刷新失败后的重试策略
+}
+
+//多实例部署时只有leader请求微信接口，follower从存储中同步leader的accessToken
+type LeaderChecker interface {
+	IsLeader() bool
+}
+
+func (wm *WechatMan) SetLeaderChecker(lc LeaderChecker){
+	wm.Lock()
+	wm.leader = lc
+	wm.Unlock()
+}
+
+//未配置选主时当前节点始终为leader
+func (wm *WechatMan) IsLeader() bool{
+	wm.RLock()
+	lc := wm.leader
+	wm.RUnlock()
+	return lc == nil || lc.IsLeader()
 }
 
 func (wm *WechatMan) AddWehcatApp(wa ...*WechatApp){
 	wm.Lock()
 	wm.apps = append(wm.apps,wa...)
 	wm.Unlock()
+	wm.reschedule(wa...)
 }
 
 func (wm *WechatMan) DelWechatAppByAppID(appID string){
 	wm.Lock()
 	newAPPs := make([]*WechatApp,0)
+	deleted := make([]*WechatApp,0)
 	for i,wa:= range wm.apps{
 		if wa.WechatConfig.AppID != appID{
 			newAPPs = append(newAPPs,wm.apps[i])
+		}else{
+			wm.detachApp(wa)
+			deleted = append(deleted,wa)
 		}
 	}
 	wm.apps = newAPPs
 	wm.Unlock()
+	wm.deleteTokens(deleted...)
 }
 
-func (wm *WechatMan) loopAccessToken(stopCh <-chan int){
-	loopChan := make(chan int,1)
-	stopLoop := make(chan int,1)
-	go func(){
-		log.Println("send signal routine start")
-	loopsig:
-		for{
-			select{
-				case <-stopLoop:
-					break loopsig
-				default:
-					loopChan<-1
-					time.Sleep(time.Second*time.Duration(wm.loopTime))
-			}
-		}
-		log.Println("send signal routine end")
-	}()
-	go func(){
-		log.Println("loop routine start")
-loop:
-		for{
-			//此处使用两个chan保证第一时间接收信号，如果使用default:time.sleep()会阻断ch消息接收，
-			//使用两个ch可以保证消息第一时间接收
-			select{
-				case <-stopCh://此处接收到消息后，wm.stop方法即返回不会阻塞后续流程，此处的退出流程无需考虑时效性
-					log.Println("loop routine end")
-					//收到stopCh信号后，此处将退出，如果不通知发送轮训信号的携程，那个携程将一直阻塞在发送消息状态
-					//虽然发送方使用了time.sleep，但是这里不要求时效性，只要保证携程能够结束不一直阻塞即可
-					stopLoop<-1
-					break loop
-				case <-loopChan:
-					wm.refreshAccessToken()
-			}
-		}
-	}()
-}
-
+//启动调度，按照每个应用的到期时间刷新accessToken和ticket
 func (wm *WechatMan) Run() error{
+	wm.Lock()
 	if wm.isRuning {
+		wm.Unlock()
 		return errors.New("wechatMan was runing")
 	}
-
-	wm.Lock()
 	wm.isRuning = true
+	wm.sched.setRecheck(time.Second*time.Duration(wm.loopTime))
+	apps := append([]*WechatApp(nil),wm.apps...)
 	wm.Unlock()
-	wm.loopAccessToken(wm.loopStopChan)
+	wm.runScheduler(wm.loopStopChan)
+	wm.reschedule(apps...)
 	return nil
 }
 
 func (wm *WechatMan) Stop(){
 	wm.loopStopChan<-1
+	wm.sched.clear()
 	wm.Lock()
 	wm.isRuning = false
 	wm.Unlock()
 }
 
-func (wm *WechatMan) refreshAccessToken(){
-	wg := sync.WaitGroup{}
-	wm.RLock()
-	for _,app := range wm.apps{
-		if app != nil{
-			app.locker.RLock()
-			if app.needUpdate || time.Since(app.updateTime) >= app.duration &&
-							 app.WechatConfig.Token != "" &&
-							 app.WechatConfig.AppSecret != ""{
-				//此处为了多个微信公众号时提高更新效率，启用子进程更新，
-				//由于外层有加锁和解锁操作，所以需要使用wg同步进程状态
-				wg.Add(1)
-				app.locker.RUnlock()
-				go app.UpdateAccessToken(&wg)
-			}else{
-				app.locker.RUnlock()
-			}
-		}
-	}
-	wm.RUnlock()
-	wg.Wait()
-
-}
-
 func (wm *WechatMan) ForceRefreshAccessToken(appids ...string){
-	wg := sync.WaitGroup{}
+	if !wm.IsLeader(){
+		log.Println("follower can't refresh accesstoken, wait for leader")
+		return
+	}
+	updated := make([]*WechatApp,0)
 	wm.RLock()
 	for _,appid := range appids{
 		for _,app := range wm.apps{
 			if app != nil{
 				app.locker.RLock()
 				if app.WechatConfig.AppID == appid{
-					//此处为了多个微信公众号时提高更新效率，启用子进程更新，
-					//由于外层有加锁和解锁操作，所以需要使用wg同步进程状态
-					wg.Add(1)
-					app.locker.RUnlock()
-					go app.UpdateAccessToken(&wg)
-				}else{
-					app.locker.RUnlock()
+					updated = append(updated,app)
 				}
+				app.locker.RUnlock()
 			}
 		}
 	}
 	wm.RUnlock()
-	wg.Wait()
+	wm.forceRefreshApps(updated...)
+}
 
+//强制刷新指定的应用，调用方需要确认是leader
+func (wm *WechatMan) forceRefreshApps(apps ...*WechatApp){
+	wg := sync.WaitGroup{}
+	for _,app := range apps{
+		//此处为了多个微信公众号时提高更新效率，启用子进程更新，使用wg同步进程状态
+		wg.Add(1)
+		go wm.updateAccessToken(app,true,&wg)
+	}
+	wg.Wait()
+	wm.saveTokens(apps...)
 }
 
 func (wm *WechatMan) Rebuild(aheadTime,loopTime int,wxconfs ...*WechatConfig) error{
@@ -245,24 +381,36 @@ func (wm *WechatMan) Rebuild(aheadTime,loopTime int,wxconfs ...*WechatConfig) er
 	wm.Lock()
 	wm.aheadTime = aheadTime
 	wm.loopTime = loopTime
+	deleted := make([]*WechatApp,0)
 	//标记删除的app
 	for _,app := range wm.apps{
 		app.locker.Lock()
+		wasDeleted := app.deleted
 		app.deleted = true
+		appid := app.WechatConfig.AppID
+		//授权方跟随所属第三方平台
+		if app.IsAuthorizer(){
+			appid = app.WechatConfig.ComponentAppID
+		}
 		for _,wxconf := range wxconfs{
-			if wxconf.AppID == app.WechatConfig.AppID{
+			if wxconf.AppID == appid{
 				app.deleted = false
 				break
 			}
 		}
+		if app.deleted && !wasDeleted{
+			deleted = append(deleted,app)
+		}
+		//重新加载配置后重新开始计算失败次数
+		app.resetRetry()
 		app.locker.Unlock()
 	}
 	for _,wxconf := range wxconfs{
 		isNew := true
 		for _,app := range wm.apps{
 			app.locker.Lock()
-			//app已标记为删除
-			if app.deleted {
+			//app已标记为删除，授权方不在配置文件中
+			if app.deleted || app.IsAuthorizer(){
 				app.locker.Unlock()
 				continue
 			}
@@ -275,6 +423,32 @@ func (wm *WechatMan) Rebuild(aheadTime,loopTime int,wxconfs ...*WechatConfig) er
 					app.needUpdate = true
 				}
 				app.WechatConfig.Token = wxconf.Token
+				app.WechatConfig.StableToken = wxconf.StableToken
+				if app.WechatConfig.Provider != wxconf.Provider || app.WechatConfig.CorpID != wxconf.CorpID{
+					app.WechatConfig.Provider = wxconf.Provider
+					app.WechatConfig.CorpID = wxconf.CorpID
+					app.needUpdate = true
+				}
+				if app.WechatConfig.ApiBaseURL != wxconf.ApiBaseURL{
+					app.WechatConfig.ApiBaseURL = wxconf.ApiBaseURL
+					app.needUpdate = true
+				}
+				if app.WechatConfig.Type != wxconf.Type{
+					app.WechatConfig.Type = wxconf.Type
+					app.needUpdate = true
+				}
+				app.WechatConfig.MsgToken = wxconf.MsgToken
+				app.WechatConfig.EncodingAESKey = wxconf.EncodingAESKey
+				app.WechatConfig.VerifyTicketFile = wxconf.VerifyTicketFile
+				if app.IsComponent() && app.authorizers == nil{
+					app.loadVerifyTicket()
+					app.loadAuthorizers()
+				}
+				if app.WechatConfig.JsapiTicket != wxconf.JsapiTicket || app.WechatConfig.CardTicket != wxconf.CardTicket{
+					app.WechatConfig.JsapiTicket = wxconf.JsapiTicket
+					app.WechatConfig.CardTicket = wxconf.CardTicket
+					app.tickets = newWechatTickets(app.WechatConfig)
+				}
 				app.locker.Unlock()
 				break
 			}
@@ -282,26 +456,46 @@ func (wm *WechatMan) Rebuild(aheadTime,loopTime int,wxconfs ...*WechatConfig) er
 		}
 
 		if isNew{
-			wm.apps = append(wm.apps,NewWechatApp(wxconf,aheadTime))
+			app := NewWechatApp(wxconf,aheadTime)
+			wm.apps = append(wm.apps,app)
+			wm.apps = append(wm.apps,app.newAuthorizerApps()...)
+		}
+	}
+	//授权方使用第三方平台的查询token
+	for _,app := range wm.apps{
+		if app.IsAuthorizer() && !app.deleted{
+			app.locker.Lock()
+			app.aheadTime = aheadTime
+			app.WechatConfig.Token = app.component.WechatConfig.Token
+			app.WechatConfig.ApiBaseURL = app.component.WechatConfig.ApiBaseURL
+			app.locker.Unlock()
 		}
 	}
 	wm.Unlock()
+	//已删除的app不能保证accessToken有效，从存储中移除
+	wm.deleteTokens(deleted...)
 	wm.Run()
 	log.Println("reload success "+time.Now().String())
 	return nil
 }
 
 func (wm *WechatMan) QueryAccessToken(appid,token string) (string,int64,error){
+	wm.RLock()
+	defer wm.RUnlock()
+	return wm.queryAccessToken(appid,token)
+}
+
+//调用方需要持有wm的读锁
+func (wm *WechatMan) queryAccessToken(appid,token string) (string,int64,error){
 	var accesstoken string
 	var expireAt int64
-	var err error
-	wm.RLock()
+	var err error = ErrAppNotFound
 	for _,app := range wm.apps{
 		app.locker.RLock()
 		if app.WechatConfig.AppID == appid && app.WechatConfig.Token == token{
 			if app.deleted{
 				accesstoken = app.accessToken
-				err = errors.New("this app is deleted,can't ensure the accesstoken is valid")
+				err = ErrAppDeleted
 				expireAt = app.updateTime.Add(app.duration).Unix()
 			}else{
 				accesstoken = app.accessToken
@@ -311,14 +505,26 @@ func (wm *WechatMan) QueryAccessToken(appid,token string) (string,int64,error){
 		}
 		app.locker.RUnlock()
 	}
-	wm.RUnlock()
-	if accesstoken == ""{
-		err = errors.New("no accesstoken for this appid and token")
+	if accesstoken == "" && err != ErrAppNotFound{
+		err = ErrTokenNotReady
 		expireAt = 0
 	}
 	return accesstoken,expireAt,err
+}
 
+//批量查询accessToken，在同一个读锁内读取，保证返回结果是同一时刻的状态
+func (wm *WechatMan) BatchQueryAccessToken(queries ...*TokenQuery) []*TokenQueryResult{
+	results := make([]*TokenQueryResult,0,len(queries))
+	wm.RLock()
+	defer wm.RUnlock()
+	for _,query := range queries{
+		result := &TokenQueryResult{AppID:query.AppID}
+		result.AccessToken,result.ExpireAt,result.Err = wm.queryAccessToken(query.AppID,query.Token)
+		results = append(results,result)
+	}
+	return results
 }
+
 var wechatMan *WechatMan
 
 //实现单利模式，返回唯一的WechatMan实例
@@ -334,12 +540,18 @@ func BuildWechatMan(aheadTime,loopTime int,wxconfs ...*WechatConfig) (*WechatMan
 		loopStopChan:make(chan int),
 		aheadTime:aheadTime,
 		loopTime:loopTime,
+		sched:newScheduler(),
+		store:store.NewMemoryStore(),
+		retry:DefaultRetryPolicy,
 	}
 
 	//根据给定的配置初始化wechatapp
 	for _,conf := range wxconfs{
-		wechatMan.apps = append(wechatMan.apps,NewWechatApp(conf,aheadTime))
+		app := NewWechatApp(conf,aheadTime)
+		wechatMan.apps = append(wechatMan.apps,app)
+		wechatMan.apps = append(wechatMan.apps,app.newAuthorizerApps()...)
 	}
+	wechatMan.startNotifier()
 	return wechatMan,nil
 }
 
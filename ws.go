@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/valyala/fasthttp"
+	"log"
+	"time"
+	"github.com/dbldqt/wechatTokenServer/websocket"
+	"github.com/dbldqt/wechatTokenServer/wechat"
+)
+
+const (
+	WS_PING_INTERVAL = 30*time.Second
+	WS_MAX_APPS = 200 //单个连接最多订阅的应用数量
+	WS_ACTION_SUBSCRIBE = "subscribe"
+	WS_ACTION_UNSUBSCRIBE = "unsubscribe"
+)
+
+//客户端发送的订阅请求
+type WsRequest struct{
+	Action string `json:"action"`
+	AppID  string `json:"appid"`
+	Token  string `json:"token"`
+}
+
+//订阅请求的回复，事件直接发送wechat.Event
+type WsReply struct{
+	Type    string `json:"type"`
+	AppID   string `json:"appid"`
+	Errcode int    `json:"errcode"`
+	Msg     string `json:"msg"`
+}
+
+//GET /v1/ws，一个连接可以订阅多个应用，每个应用使用各自的token校验
+//推送token_rotated，app_deleted，refresh_failed事件，与NotifyUrl共用事件总线
+func wsHandler(ctx *fasthttp.RequestCtx){
+	wechatman,ok := getWechatMan(ctx)
+	if !ok{
+		return
+	}
+	remote := ctx.RemoteIP().String()
+	err := websocket.Upgrade(ctx,func(conn *websocket.Conn){
+		log.Println(remote+" websocket connected")
+		wsSession(wechatman,conn)
+		log.Println(remote+" websocket disconnected")
+	})
+	if err != nil{
+		replyError(ctx,fasthttp.StatusBadRequest,ERRCODE_BAD_REQUEST,err.Error())
+	}
+}
+
+func wsSession(wechatman *wechat.WechatMan,conn *websocket.Conn){
+	sub := wechat.GetEventBus().Subscribe()
+	defer sub.Close()
+	done := make(chan int)
+	go func(){
+		defer close(done)
+		for{
+			_,message,err := conn.ReadMessage()
+			if err != nil{
+				return
+			}
+			reply := wsAction(wechatman,sub,message)
+			if err := wsWrite(conn,reply);err != nil{
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(WS_PING_INTERVAL)
+	defer ping.Stop()
+	for{
+		select{
+		case <-done:
+			return
+		case event,ok := <-sub.C:
+			//处理不过来被关闭订阅，客户端需要重连后重新订阅
+			if !ok{
+				return
+			}
+			if err := wsWrite(conn,event);err != nil{
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteMessage(websocket.PING_MESSAGE,nil);err != nil{
+				return
+			}
+		}
+	}
+}
+
+func wsAction(wechatman *wechat.WechatMan,sub *wechat.Subscription,message []byte) *WsReply{
+	request := WsRequest{}
+	if err := json.Unmarshal(message,&request);err != nil || request.AppID == ""{
+		return &WsReply{Type:"error",Errcode:ERRCODE_BAD_REQUEST,Msg:"message need action and appid"}
+	}
+	reply := &WsReply{Type:request.Action,AppID:request.AppID,Msg:"success"}
+	switch request.Action{
+	case WS_ACTION_SUBSCRIBE:
+		status,err := wechatman.QueryAppStatus(request.AppID,request.Token)
+		if err != nil{
+			_,reply.Errcode = wechatErrorStatus(err)
+			reply.Msg = err.Error()
+			return reply
+		}
+		if sub.Len() >= WS_MAX_APPS{
+			reply.Errcode = ERRCODE_BAD_REQUEST
+			reply.Msg = "too many apps subscribed"
+			return reply
+		}
+		sub.Add(status.Key())
+	case WS_ACTION_UNSUBSCRIBE:
+		sub.RemoveAppID(request.AppID)
+	default:
+		reply.Type = "error"
+		reply.Errcode = ERRCODE_BAD_REQUEST
+		reply.Msg = "unknown action "+request.Action
+	}
+	return reply
+}
+
+func wsWrite(conn *websocket.Conn,message interface{}) error{
+	data,err := json.Marshal(message)
+	if err != nil{
+		return err
+	}
+	return conn.WriteMessage(websocket.TEXT_MESSAGE,data)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"github.com/dbldqt/wechatTokenServer/wechat"
+	"testing"
+)
+
+func TestWsAction(test *testing.T){
+	wechatman := testWechatMan(test)
+	sub := wechat.GetEventBus().Subscribe()
+	defer sub.Close()
+
+	if reply := wsAction(wechatman,sub,[]byte(`{"action":"subscribe","appid":"wx_test","token":"wrong"}`));reply.Errcode != ERRCODE_NOT_FOUND{
+		test.Error("wrong token should not subscribe",reply)
+	}
+	if reply := wsAction(wechatman,sub,[]byte(`{"action":"subscribe","appid":"wx_test","token":"token"}`));reply.Errcode != ERRCODE_SUCCESS || sub.Len() != 1{
+		test.Error("subscribe error",reply)
+	}
+	wechat.GetEventBus().Publish(&wechat.Event{Type:wechat.EVENT_APP_DELETED,AppID:"wx_test"})
+	if event := <-sub.C;event.Type != wechat.EVENT_APP_DELETED{
+		test.Error("subscriber should receive event",event)
+	}
+	if reply := wsAction(wechatman,sub,[]byte(`{"action":"unsubscribe","appid":"wx_test"}`));reply.Errcode != ERRCODE_SUCCESS || sub.Len() != 0{
+		test.Error("unsubscribe error",reply)
+	}
+	if reply := wsAction(wechatman,sub,[]byte(`{"action":"nothing","appid":"wx_test"}`));reply.Type != "error"{
+		test.Error("unknown action should error",reply)
+	}
+}